@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+var errEntityInUse = errors.New("Entity still has test cases")
+
+const entityColumns = `id, name, COALESCE(description, ''), project_id, json_data`
+
+func scanEntity(row interface{ Scan(...interface{}) error }) (Entity, error) {
+	var e Entity
+	var jsonData []byte
+	err := row.Scan(&e.ID, &e.Name, &e.Description, &e.ProjectID, &jsonData)
+	if len(jsonData) > 0 {
+		e.JSONData = jsonData
+	}
+	return e, err
+}
+
+// containmentFilter builds a JSONB containment document from the query
+// string, for use with json_data @> so the GIN index applies. It accepts a
+// whole document in "contains" and single fields as "data.<path>=value",
+// where a dotted path addresses nested objects. Values are read as JSON
+// literals, so data.count=3 matches a number, and anything that is not valid
+// JSON is taken as a string. It returns nil when the query has no filter.
+func containmentFilter(q url.Values) (json.RawMessage, error) {
+	doc := map[string]interface{}{}
+	if raw := q.Get("contains"); raw != "" {
+		var v interface{}
+		err := json.Unmarshal([]byte(raw), &v)
+		obj, ok := v.(map[string]interface{})
+		if err != nil || !ok {
+			return nil, validationError("contains must be a JSON object")
+		}
+		doc = obj
+	}
+
+	for name, values := range q {
+		path, ok := strings.CutPrefix(name, "data.")
+		if !ok || len(values) == 0 {
+			continue
+		}
+		parts := strings.Split(path, ".")
+		obj := doc
+		for i, part := range parts {
+			if part == "" {
+				return nil, validationError(fmt.Sprintf("Invalid filter path %q", name))
+			}
+			existing, set := obj[part]
+			if i == len(parts)-1 {
+				if set {
+					return nil, validationError(fmt.Sprintf("Filter %q conflicts with another filter", name))
+				}
+				obj[part] = filterValue(values[0])
+				break
+			}
+			if !set {
+				next := map[string]interface{}{}
+				obj[part] = next
+				obj = next
+				continue
+			}
+			next, ok := existing.(map[string]interface{})
+			if !ok {
+				return nil, validationError(fmt.Sprintf("Filter %q conflicts with another filter", name))
+			}
+			obj = next
+		}
+	}
+
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(doc)
+}
+
+func filterValue(raw string) interface{} {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return raw
+	}
+	return v
+}
+
+func listEntities(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := containmentFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+entityColumns+` FROM entities
+		WHERE project_id = $1 AND deleted_at IS NULL AND ($2::jsonb IS NULL OR json_data @> $2::jsonb)
+		ORDER BY name
+	`, projectID, nullJSON(filter))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	entities := []Entity{}
+	for rows.Next() {
+		e, err := scanEntity(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		entities = append(entities, e)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entities)
+}
+
+func getEntity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+
+	entity, err := scanEntity(db.QueryRow(`SELECT `+entityColumns+` FROM entities WHERE id = $1 AND deleted_at IS NULL`, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Entity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entity)
+}
+
+// updateEntity replaces the entity's name, description and json_data. An
+// entity cannot move to another project.
+func updateEntity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+
+	var entity Entity
+	if err := json.NewDecoder(r.Body).Decode(&entity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if entity.Name == "" {
+		http.Error(w, "Entity name is required", http.StatusBadRequest)
+		return
+	}
+
+	var projectID uuid.UUID
+	err = db.QueryRow(`SELECT project_id FROM entities WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Entity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entity.ProjectID != uuid.Nil && entity.ProjectID != projectID {
+		http.Error(w, "Entity cannot be moved to another project", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := scanEntity(db.QueryRow(`
+		UPDATE entities SET name = $2, description = $3, json_data = $4
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING `+entityColumns,
+		id, entity.Name, entity.Description, nullJSON(entity.JSONData)))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Entity not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func deleteEntity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+
+	err = softDeleteEntity(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Entity not found", http.StatusNotFound)
+		return
+	}
+	if err == errEntityInUse {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// softDeleteEntity marks the entity deleted. Deleting the row would cascade
+// into its test cases and their run history, including cases that are
+// themselves soft-deleted, so the row stays. An entity with live cases
+// cannot be deleted.
+func softDeleteEntity(id uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var found bool
+	err = tx.QueryRow(`SELECT true FROM entities WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&found)
+	if err != nil {
+		return err
+	}
+
+	var inUse bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM test_cases WHERE entity_id = $1 AND deleted_at IS NULL)`, id).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return errEntityInUse
+	}
+
+	if _, err := tx.Exec(`UPDATE entities SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+const secretMask = "***"
+
+type Environment struct {
+	ID          uuid.UUID         `json:"id"`
+	ProjectID   uuid.UUID         `json:"project_id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Variables   map[string]string `json:"variables"`
+	Secrets     map[string]string `json:"secrets,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// runEnvironment holds the variables interpolated into executor specs and the
+// secret values that must not show up in stored results.
+type runEnvironment struct {
+	vars    map[string]interface{}
+	secrets []string
+}
+
+const environmentColumns = `id, project_id, name, COALESCE(description, ''), variables, secrets, created_at`
+
+func scanEnvironment(row interface{ Scan(...interface{}) error }) (Environment, error) {
+	var env Environment
+	var vars, secrets []byte
+	err := row.Scan(&env.ID, &env.ProjectID, &env.Name, &env.Description, &vars, &secrets, &env.CreatedAt)
+	if err != nil {
+		return env, err
+	}
+	if err := json.Unmarshal(vars, &env.Variables); err != nil {
+		return env, err
+	}
+	if err := json.Unmarshal(secrets, &env.Secrets); err != nil {
+		return env, err
+	}
+	return env, nil
+}
+
+// masked returns a copy safe to send to clients: secret names stay visible,
+// their values do not.
+func (env Environment) masked() Environment {
+	secrets := make(map[string]string, len(env.Secrets))
+	for k := range env.Secrets {
+		secrets[k] = secretMask
+	}
+	env.Secrets = secrets
+	return env
+}
+
+func loadRunEnvironment(id *uuid.UUID) (*runEnvironment, error) {
+	if id == nil {
+		return &runEnvironment{}, nil
+	}
+
+	env, err := scanEnvironment(db.QueryRow(`SELECT `+environmentColumns+` FROM environments WHERE id = $1 AND deleted_at IS NULL`, *id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("environment %s no longer exists", *id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	re := &runEnvironment{vars: make(map[string]interface{}, len(env.Variables)+len(env.Secrets))}
+	for k, v := range env.Variables {
+		re.vars[k] = v
+	}
+	for k, v := range env.Secrets {
+		re.vars[k] = v
+		if v != "" {
+			re.secrets = append(re.secrets, v)
+		}
+	}
+	// Longer secrets first so that a secret containing another one is
+	// masked as a whole.
+	sort.Slice(re.secrets, func(i, j int) bool { return len(re.secrets[i]) > len(re.secrets[j]) })
+	return re, nil
+}
+
+// with returns the environment variables overlaid with row, which wins on
+// name clashes.
+func (re *runEnvironment) with(row map[string]interface{}) map[string]interface{} {
+	if len(re.vars) == 0 {
+		return row
+	}
+	vars := make(map[string]interface{}, len(re.vars)+len(row))
+	for k, v := range re.vars {
+		vars[k] = v
+	}
+	for k, v := range row {
+		vars[k] = v
+	}
+	return vars
+}
+
+func (re *runEnvironment) mask(s string) string {
+	for _, secret := range re.secrets {
+		s = strings.ReplaceAll(s, secret, secretMask)
+	}
+	return s
+}
+
+func (re *runEnvironment) maskJSON(data json.RawMessage) json.RawMessage {
+	if len(re.secrets) == 0 || len(data) == 0 {
+		return data
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return data
+	}
+	masked, err := json.Marshal(re.maskNode(doc))
+	if err != nil {
+		return data
+	}
+	return masked
+}
+
+func (re *runEnvironment) maskNode(node interface{}) interface{} {
+	switch n := node.(type) {
+	case string:
+		return re.mask(n)
+	case []interface{}:
+		for i := range n {
+			n[i] = re.maskNode(n[i])
+		}
+	case map[string]interface{}:
+		for k := range n {
+			n[k] = re.maskNode(n[k])
+		}
+	}
+	return node
+}
+
+func writeEnvironmentError(w http.ResponseWriter, err error) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		http.Error(w, "Environment name already exists in project", http.StatusConflict)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func createEnvironment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var env Environment
+	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if env.Name == "" {
+		http.Error(w, "Environment name is required", http.StatusBadRequest)
+		return
+	}
+	if env.ID == uuid.Nil {
+		env.ID = uuid.New()
+	}
+	env.ProjectID = projectID
+	if env.Variables == nil {
+		env.Variables = map[string]string{}
+	}
+	if env.Secrets == nil {
+		env.Secrets = map[string]string{}
+	}
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1)", projectID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	vars, _ := json.Marshal(env.Variables)
+	secrets, _ := json.Marshal(env.Secrets)
+	err = db.QueryRow(`
+		INSERT INTO environments (id, project_id, name, description, variables, secrets)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING created_at
+	`, env.ID, env.ProjectID, env.Name, env.Description, vars, secrets).Scan(&env.CreatedAt)
+	if err != nil {
+		writeEnvironmentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(env.masked())
+}
+
+func listEnvironments(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT `+environmentColumns+` FROM environments WHERE project_id = $1 AND deleted_at IS NULL ORDER BY name`, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	envs := []Environment{}
+	for rows.Next() {
+		env, err := scanEnvironment(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		envs = append(envs, env.masked())
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(envs)
+}
+
+func getEnvironment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid environment ID", http.StatusBadRequest)
+		return
+	}
+
+	env, err := scanEnvironment(db.QueryRow(`SELECT `+environmentColumns+` FROM environments WHERE id = $1 AND deleted_at IS NULL`, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Environment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(env.masked())
+}
+
+// updateEnvironment replaces name, description and variables. Secrets are
+// replaced only when the request carries them, and a secret sent back with
+// the mask value keeps its stored value.
+func updateEnvironment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid environment ID", http.StatusBadRequest)
+		return
+	}
+
+	var req Environment
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Environment name is required", http.StatusBadRequest)
+		return
+	}
+
+	env, err := scanEnvironment(db.QueryRow(`SELECT `+environmentColumns+` FROM environments WHERE id = $1 AND deleted_at IS NULL`, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Environment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	env.Name = req.Name
+	env.Description = req.Description
+	env.Variables = req.Variables
+	if env.Variables == nil {
+		env.Variables = map[string]string{}
+	}
+	if req.Secrets != nil {
+		secrets := make(map[string]string, len(req.Secrets))
+		for k, v := range req.Secrets {
+			if v == secretMask {
+				v = env.Secrets[k]
+			}
+			secrets[k] = v
+		}
+		env.Secrets = secrets
+	}
+
+	vars, _ := json.Marshal(env.Variables)
+	secrets, _ := json.Marshal(env.Secrets)
+	_, err = db.Exec(`
+		UPDATE environments SET name = $2, description = $3, variables = $4, secrets = $5
+		WHERE id = $1 AND deleted_at IS NULL
+	`, env.ID, env.Name, env.Description, vars, secrets)
+	if err != nil {
+		writeEnvironmentError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(env.masked())
+}
+
+func deleteEnvironment(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid environment ID", http.StatusBadRequest)
+		return
+	}
+
+	// Environments are only marked deleted so that past runs still say
+	// which one they used, and queued runs fail instead of running without it.
+	res, err := db.Exec(`UPDATE environments SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Environment not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"zis/internal/config"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+const (
+	runEventsChannel = "run_events"
+
+	runEventSnapshot     = "snapshot"
+	runEventCaseStarted  = "case_started"
+	runEventCaseFinished = "case_finished"
+	runEventRunFinished  = "run_finished"
+)
+
+type RunEvent struct {
+	Type       string     `json:"type"`
+	RunID      uuid.UUID  `json:"run_id"`
+	TestCaseID *uuid.UUID `json:"test_case_id,omitempty"`
+	Status     string     `json:"status,omitempty"`
+	Message    string     `json:"message,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"`
+	Total      int        `json:"total"`
+	Passed     int        `json:"passed"`
+	Failed     int        `json:"failed"`
+	Errors     int        `json:"errors"`
+	Skipped    int        `json:"skipped"`
+	Blocked    int        `json:"blocked"`
+}
+
+func newRunEvent(eventType string, run *TestRun) RunEvent {
+	return RunEvent{
+		Type:    eventType,
+		RunID:   run.ID,
+		Status:  run.Status,
+		Total:   run.Total,
+		Passed:  run.Passed,
+		Failed:  run.Failed,
+		Errors:  run.Errors,
+		Skipped: run.Skipped,
+		Blocked: run.Blocked,
+	}
+}
+
+// runEventHub fans run events out to the SSE streams of this replica.
+type runEventHub struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[chan RunEvent]struct{}
+}
+
+var runEvents = &runEventHub{subs: make(map[uuid.UUID]map[chan RunEvent]struct{})}
+
+func (h *runEventHub) subscribe(runID uuid.UUID) (chan RunEvent, func()) {
+	ch := make(chan RunEvent, 64)
+
+	h.mu.Lock()
+	if h.subs[runID] == nil {
+		h.subs[runID] = make(map[chan RunEvent]struct{})
+	}
+	h.subs[runID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[runID], ch)
+		if len(h.subs[runID]) == 0 {
+			delete(h.subs, runID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// publish never blocks: a stream that cannot keep up loses events rather
+// than stalling every other subscriber.
+func (h *runEventHub) publish(ev RunEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs[ev.RunID] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// maxEventMessageBytes keeps NOTIFY payloads well under the 8000 byte limit
+// of Postgres, even after JSON escaping. Clients that need the full message
+// fetch the run.
+const maxEventMessageBytes = 512
+
+// notifyRunEvent sends the event through Postgres NOTIFY so that streams on
+// every replica get it. Inside a transaction it is only delivered on commit.
+func notifyRunEvent(e execer, ev RunEvent) error {
+	ev.Message = truncateUTF8(ev.Message, maxEventMessageBytes)
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = e.Exec(`SELECT pg_notify($1, $2)`, runEventsChannel, string(payload))
+	return err
+}
+
+// notifyRunEventTx notifies inside a savepoint: live progress is best effort
+// and a failed notify must not abort the transaction it is part of.
+func notifyRunEventTx(tx *sql.Tx, ev RunEvent) {
+	if _, err := tx.Exec(`SAVEPOINT run_event`); err != nil {
+		log.Printf("Failed to publish %s event of run %s: %v", ev.Type, ev.RunID, err)
+		return
+	}
+	if err := notifyRunEvent(tx, ev); err != nil {
+		log.Printf("Failed to publish %s event of run %s: %v", ev.Type, ev.RunID, err)
+		if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT run_event`); err != nil {
+			log.Printf("Failed to roll back run event savepoint: %v", err)
+		}
+		return
+	}
+	tx.Exec(`RELEASE SAVEPOINT run_event`)
+}
+
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
+
+// listenRunEvents feeds notifications from all replicas into the local hub.
+func listenRunEvents(ctx context.Context) {
+	cfg := config.GetConfig()
+	listener := pq.NewListener(cfg.Database.DSN(), time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Run events listener: %v", err)
+		}
+	})
+	if err := listener.Listen(runEventsChannel); err != nil {
+		log.Printf("Failed to listen for run events: %v", err)
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification means the connection was re-established.
+				if n == nil {
+					continue
+				}
+				var ev RunEvent
+				if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+					log.Printf("Invalid run event: %v", err)
+					continue
+				}
+				runEvents.publish(ev)
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+}
+
+func streamRunEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	runID, err := uuid.Parse(ps.ByName("runId"))
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribe before reading the snapshot so that nothing happening in
+	// between is missed.
+	events, unsubscribe := runEvents.subscribe(runID)
+	defer unsubscribe()
+
+	run, err := scanRun(db.QueryRow(`SELECT `+runColumns+` FROM test_runs WHERE id = $1`, runID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent := func(ev RunEvent) {
+		data, _ := json.Marshal(ev)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		flusher.Flush()
+	}
+
+	writeEvent(newRunEvent(runEventSnapshot, &run))
+	if run.FinishedAt != nil {
+		writeEvent(newRunEvent(runEventRunFinished, &run))
+		return
+	}
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case ev := <-events:
+			writeEvent(ev)
+			if ev.Type == runEventRunFinished {
+				return
+			}
+		}
+	}
+}
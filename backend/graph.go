@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// findCycle returns the IDs forming a dependency cycle, or nil if edges
+// (test case -> cases it depends on) form a DAG.
+func findCycle(edges map[uuid.UUID][]uuid.UUID) []uuid.UUID {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	state := make(map[uuid.UUID]int, len(edges))
+	var stack []uuid.UUID
+
+	var visit func(id uuid.UUID) []uuid.UUID
+	visit = func(id uuid.UUID) []uuid.UUID {
+		state[id] = inProgress
+		stack = append(stack, id)
+		for _, dep := range edges[id] {
+			switch state[dep] {
+			case inProgress:
+				for i, s := range stack {
+					if s == dep {
+						return append(append([]uuid.UUID{}, stack[i:]...), dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[id] = done
+		return nil
+	}
+
+	for id := range edges {
+		if state[id] == unvisited {
+			if cycle := visit(id); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// validateDependencies checks that every depends_on target exists and that
+// the stored dependencies together with the given cases stay acyclic. Only
+// the part of the graph reachable from the new dependencies is read, and its
+// cases are locked so that concurrent saves cannot close a cycle together.
+func validateDependencies(tx *sql.Tx, cases []TestCase) error {
+	known := make(map[uuid.UUID]bool, len(cases))
+	touched := make([]uuid.UUID, 0, len(cases))
+	for _, tc := range cases {
+		known[tc.ID] = true
+		touched = append(touched, tc.ID)
+	}
+
+	var targets []uuid.UUID
+	for _, tc := range cases {
+		for _, dep := range tc.DependsOn {
+			if dep == tc.ID {
+				return validationError(fmt.Sprintf("test case %s depends on itself", tc.ID))
+			}
+			if !known[dep] {
+				targets = append(targets, dep)
+			}
+		}
+	}
+	targets = uniqueIDs(targets)
+
+	if len(targets) > 0 {
+		existing := make(map[uuid.UUID]bool, len(targets))
+		rows, err := tx.Query(`SELECT id FROM test_cases WHERE id = ANY($1) AND deleted_at IS NULL`, pq.Array(targets))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			existing[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, tc := range cases {
+			for _, dep := range tc.DependsOn {
+				if !known[dep] && !existing[dep] {
+					return validationError(fmt.Sprintf("test case %s depends on unknown test case %s", tc.ID, dep))
+				}
+			}
+		}
+	}
+
+	edges, err := lockReachableDependencies(tx, touched, targets)
+	if err != nil {
+		return err
+	}
+	for _, tc := range cases {
+		edges[tc.ID] = tc.DependsOn
+	}
+
+	if cycle := findCycle(edges); cycle != nil {
+		ids := make([]string, len(cycle))
+		for i, id := range cycle {
+			ids[i] = id.String()
+		}
+		return validationError("dependency cycle: " + strings.Join(ids, " -> "))
+	}
+	return nil
+}
+
+// reachableDependencies follows the stored edges from the seeds, skipping
+// the edges of the touched cases since those are being replaced.
+const reachableDependencies = `
+	WITH RECURSIVE reach(id) AS (
+		SELECT unnest($1::uuid[])
+		UNION
+		SELECT d.depends_on_id FROM test_case_dependencies d JOIN reach r ON d.test_case_id = r.id
+		WHERE NOT d.test_case_id = ANY($2)
+	)
+	SELECT r.id, d.depends_on_id
+	FROM reach r LEFT JOIN test_case_dependencies d ON d.test_case_id = r.id AND NOT d.test_case_id = ANY($2)
+`
+
+// lockReachableDependencies locks the touched cases and every case reachable
+// from the seeds, then returns the edges between them. The walk is repeated
+// until no case outside the locked set has become reachable meanwhile.
+func lockReachableDependencies(tx *sql.Tx, touched, seeds []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	locked := make(map[uuid.UUID]bool)
+	for {
+		edges := make(map[uuid.UUID][]uuid.UUID)
+		var toLock []uuid.UUID
+		for _, id := range touched {
+			if !locked[id] {
+				toLock = append(toLock, id)
+			}
+		}
+
+		rows, err := tx.Query(reachableDependencies, pq.Array(seeds), pq.Array(touched))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id uuid.UUID
+			var dep uuid.NullUUID
+			if err := rows.Scan(&id, &dep); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if dep.Valid {
+				edges[id] = append(edges[id], dep.UUID)
+			}
+			if !locked[id] {
+				locked[id] = true
+				toLock = append(toLock, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		if len(toLock) == 0 {
+			return edges, nil
+		}
+		for _, id := range touched {
+			locked[id] = true
+		}
+		_, err = tx.Exec(`SELECT 1 FROM test_cases WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(uniqueIDs(toLock)))
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func saveDependencies(tx *sql.Tx, tc TestCase) error {
+	if _, err := tx.Exec(`DELETE FROM test_case_dependencies WHERE test_case_id = $1`, tc.ID); err != nil {
+		return err
+	}
+	for _, dep := range tc.DependsOn {
+		_, err := tx.Exec(`
+			INSERT INTO test_case_dependencies (test_case_id, depends_on_id) VALUES ($1, $2)
+			ON CONFLICT DO NOTHING
+		`, tc.ID, dep)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
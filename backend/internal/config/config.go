@@ -1,8 +1,10 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -12,6 +14,11 @@ type Config struct {
 	FrontendServer `yaml:"frontend"`
 	BackendServer  `yaml:"backend"`
 	Database       `yaml:"database"`
+	Runner         `yaml:"runner"`
+	Shell          `yaml:"shell"`
+	Webhooks       `yaml:"webhooks"`
+	Requirements   `yaml:"requirements"`
+	Datasources    map[string]string `yaml:"datasources"`
 }
 
 type Database struct {
@@ -22,6 +29,11 @@ type Database struct {
 	DBname   string `yaml:"dbname" env:"DBname" env-default:"postgresql"`
 }
 
+func (d Database) DSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		d.Host, d.Port, d.User, d.Password, d.DBname)
+}
+
 type FrontendServer struct {
 	Host string `yaml:"host" env:"Host" env-default:"localhost"`
 	Port string `yaml:"port" env:"Port" env-default:"3000"`
@@ -32,6 +44,47 @@ type BackendServer struct {
 	Port string `yaml:"port" env:"Port" env-default:"8080"`
 }
 
+type Runner struct {
+	Workers      int           `yaml:"workers" env:"Workers" env-default:"4"`
+	PollInterval time.Duration `yaml:"poll_interval" env:"PollInterval" env-default:"2s"`
+	Parallelism  int           `yaml:"parallelism" env:"Parallelism" env-default:"4"`
+	CaseTimeout  time.Duration `yaml:"case_timeout" env:"CaseTimeout" env-default:"30s"`
+	MaxAttempts  int           `yaml:"max_attempts" env:"MaxAttempts" env-default:"1"`
+	RetryBackoff time.Duration `yaml:"retry_backoff" env:"RetryBackoff" env-default:"1s"`
+}
+
+type Shell struct {
+	Enabled         bool          `yaml:"enabled" env:"ShellEnabled" env-default:"false"`
+	AllowedCommands []string      `yaml:"allowed_commands"`
+	WorkDir         string        `yaml:"work_dir" env:"ShellWorkDir"`
+	MaxOutputBytes  int64         `yaml:"max_output_bytes" env:"ShellMaxOutputBytes" env-default:"65536"`
+	Timeout         time.Duration `yaml:"timeout" env:"ShellTimeout" env-default:"1m"`
+}
+
+type Webhooks struct {
+	MaxAttempts      int           `yaml:"max_attempts" env:"WebhookMaxAttempts" env-default:"5"`
+	Backoff          time.Duration `yaml:"backoff" env:"WebhookBackoff" env-default:"1s"`
+	Timeout          time.Duration `yaml:"timeout" env:"WebhookTimeout" env-default:"10s"`
+	DispatchInterval time.Duration `yaml:"dispatch_interval" env:"WebhookDispatchInterval" env-default:"1s"`
+	BatchSize        int           `yaml:"batch_size" env:"WebhookBatchSize" env-default:"20"`
+}
+
+type Requirements struct {
+	Provider          string            `yaml:"provider" env:"RequirementsProvider" env-default:"native"`
+	BaseURL           string            `yaml:"base_url" env:"RequirementsBaseURL"`
+	ListPath          string            `yaml:"list_path" env:"RequirementsListPath" env-default:"/projects/{project}/requirements"`
+	VerdictPath       string            `yaml:"verdict_path" env:"RequirementsVerdictPath" env-default:"/projects/{project}/requirements/{id}/verifications"`
+	AuthHeader        string            `yaml:"auth_header" env:"RequirementsAuthHeader" env-default:"Authorization"`
+	AuthToken         string            `yaml:"auth_token" env:"RequirementsAuthToken"`
+	Timeout           time.Duration     `yaml:"timeout" env:"RequirementsTimeout" env-default:"10s"`
+	SyncInterval      time.Duration     `yaml:"sync_interval" env:"RequirementsSyncInterval" env-default:"15m"`
+	WritebackInterval time.Duration     `yaml:"writeback_interval" env:"RequirementsWritebackInterval" env-default:"1m"`
+	Fields            map[string]string `yaml:"fields"`
+	Statuses          map[string]string `yaml:"statuses"`
+	// Projects maps local project IDs to the project keys of the provider.
+	Projects map[string]string `yaml:"projects"`
+}
+
 func GetConfig() *Config {
 	stage := os.Getenv("STAGE")
 
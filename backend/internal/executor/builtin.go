@@ -0,0 +1,39 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Noop always passes. Useful for smoke-checking the run pipeline itself.
+type Noop struct{}
+
+func (Noop) Execute(ctx context.Context, spec json.RawMessage) (Result, error) {
+	return Result{Status: StatusPassed}, nil
+}
+
+// Assert compares the "actual" and "expected" values of the spec.
+type Assert struct{}
+
+type assertSpec struct {
+	Actual   interface{} `json:"actual"`
+	Expected interface{} `json:"expected"`
+}
+
+func (Assert) Execute(ctx context.Context, spec json.RawMessage) (Result, error) {
+	var s assertSpec
+	if err := json.Unmarshal(spec, &s); err != nil {
+		return Result{}, fmt.Errorf("invalid assert spec: %w", err)
+	}
+
+	if !reflect.DeepEqual(s.Actual, s.Expected) {
+		return Result{
+			Status:  StatusFailed,
+			Message: fmt.Sprintf("expected %v, got %v", s.Expected, s.Actual),
+		}, nil
+	}
+
+	return Result{Status: StatusPassed}, nil
+}
@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusError   = "error"
+	StatusSkipped = "skipped"
+)
+
+type Result struct {
+	Status    string
+	Message   string
+	Output    json.RawMessage
+	StartedAt time.Time
+	Duration  time.Duration
+}
+
+// Executor runs a single test case described by the json_data of the case.
+// A returned error means the case could not be executed at all, while a
+// failed check is reported through Result.Status.
+type Executor interface {
+	Execute(ctx context.Context, spec json.RawMessage) (Result, error)
+}
+
+type Registry struct {
+	executors map[string]Executor
+}
+
+func NewRegistry() *Registry {
+	return &Registry{executors: make(map[string]Executor)}
+}
+
+func (r *Registry) Register(name string, e Executor) {
+	r.executors[name] = e
+}
+
+func (r *Registry) Run(ctx context.Context, spec json.RawMessage) Result {
+	start := time.Now()
+	result, err := r.run(ctx, spec)
+	if err != nil {
+		result = Result{Status: StatusError, Message: err.Error()}
+	}
+	result.StartedAt = start
+	result.Duration = time.Since(start)
+	return result
+}
+
+func (r *Registry) run(ctx context.Context, spec json.RawMessage) (Result, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if len(spec) == 0 {
+		return Result{}, fmt.Errorf("test case has no json_data")
+	}
+	if err := json.Unmarshal(spec, &header); err != nil {
+		return Result{}, fmt.Errorf("invalid json_data: %w", err)
+	}
+	if header.Type == "" {
+		return Result{}, fmt.Errorf("json_data has no executor type")
+	}
+
+	e, ok := r.executors[header.Type]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown executor type %q", header.Type)
+	}
+
+	return e.Execute(ctx, spec)
+}
@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type failingExecutor struct{}
+
+func (failingExecutor) Execute(ctx context.Context, spec json.RawMessage) (Result, error) {
+	return Result{Status: StatusPassed}, errors.New("cannot execute")
+}
+
+func TestRegistryRun(t *testing.T) {
+	r := NewRegistry()
+	r.Register("noop", Noop{})
+	r.Register("assert", Assert{})
+	r.Register("broken", failingExecutor{})
+
+	tests := []struct {
+		name    string
+		spec    string
+		status  string
+		message string
+	}{
+		{"noop", `{"type":"noop"}`, StatusPassed, ""},
+		{"assert equal", `{"type":"assert","actual":{"a":[1,"x"]},"expected":{"a":[1,"x"]}}`, StatusPassed, ""},
+		{"assert different", `{"type":"assert","actual":1,"expected":2}`, StatusFailed, "expected 2, got 1"},
+		{"assert invalid", `{"type":"assert","actual":`, StatusError, "invalid json_data"},
+		{"empty spec", ``, StatusError, "no json_data"},
+		{"missing type", `{"actual":1}`, StatusError, "no executor type"},
+		{"unknown type", `{"type":"nope"}`, StatusError, `unknown executor type "nope"`},
+		{"executor error", `{"type":"broken"}`, StatusError, "cannot execute"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := r.Run(context.Background(), json.RawMessage(tt.spec))
+			if result.Status != tt.status {
+				t.Fatalf("status = %q, want %q (message %q)", result.Status, tt.status, result.Message)
+			}
+			if !strings.Contains(result.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", result.Message, tt.message)
+			}
+			if result.StartedAt.IsZero() {
+				t.Error("StartedAt is not set")
+			}
+		})
+	}
+}
+
+func TestAssertInvalidSpec(t *testing.T) {
+	_, err := Assert{}.Execute(context.Background(), json.RawMessage(`[`))
+	if err == nil {
+		t.Fatal("expected an error for an invalid spec")
+	}
+}
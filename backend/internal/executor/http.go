@@ -0,0 +1,308 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultMaxBodyBytes = 1 << 20
+
+// HTTP sends the request described by the spec and checks the response
+// against its assertions. The request, response and per-assertion outcome are
+// returned as the result output. A request that gets no response is an
+// error rather than a failure.
+type HTTP struct {
+	Client       *http.Client
+	MaxBodyBytes int64
+}
+
+type httpSpec struct {
+	Request struct {
+		Method  string            `json:"method"`
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+		Body    json.RawMessage   `json:"body"`
+	} `json:"request"`
+	Variables  map[string]string `json:"variables"`
+	Assertions []httpAssertion   `json:"assertions"`
+}
+
+type httpAssertion struct {
+	Type     string          `json:"type"`
+	Path     string          `json:"path,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Equals   json.RawMessage `json:"equals,omitempty"`
+	Contains json.RawMessage `json:"contains,omitempty"`
+	Matches  string          `json:"matches,omitempty"`
+	UnderMs  int64           `json:"under_ms,omitempty"`
+}
+
+type httpTranscript struct {
+	Request    httpRequestLog    `json:"request"`
+	Response   *httpResponseLog  `json:"response,omitempty"`
+	Assertions []assertionResult `json:"assertions"`
+}
+
+type httpRequestLog struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+type httpResponseLog struct {
+	Status     int                 `json:"status"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body"`
+	Truncated  bool                `json:"truncated,omitempty"`
+	DurationMs int64               `json:"duration_ms"`
+}
+
+type assertionResult struct {
+	httpAssertion
+	Actual  interface{} `json:"actual,omitempty"`
+	Passed  bool        `json:"passed"`
+	Message string      `json:"message,omitempty"`
+}
+
+func (e HTTP) Execute(ctx context.Context, spec json.RawMessage) (Result, error) {
+	var s httpSpec
+	if err := json.Unmarshal(spec, &s); err != nil {
+		return Result{}, fmt.Errorf("invalid http spec: %w", err)
+	}
+	if s.Request.URL == "" {
+		return Result{}, fmt.Errorf("http spec has no request.url")
+	}
+
+	reqLog, req, err := buildHTTPRequest(ctx, s)
+	if err != nil {
+		return Result{}, err
+	}
+	transcript := httpTranscript{Request: reqLog, Assertions: []assertionResult{}}
+
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxBody := e.MaxBodyBytes
+	if maxBody <= 0 {
+		maxBody = defaultMaxBodyBytes
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return transcriptResult(StatusError, fmt.Sprintf("request failed: %v", err), transcript), nil
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody+1))
+	elapsed := time.Since(start)
+	if err != nil {
+		return transcriptResult(StatusError, fmt.Sprintf("reading response: %v", err), transcript), nil
+	}
+
+	truncated := int64(len(body)) > maxBody
+	if truncated {
+		body = body[:maxBody]
+	}
+	transcript.Response = &httpResponseLog{
+		Status:     resp.StatusCode,
+		Headers:    resp.Header,
+		Body:       string(body),
+		Truncated:  truncated,
+		DurationMs: elapsed.Milliseconds(),
+	}
+
+	var failures []string
+	for _, a := range s.Assertions {
+		res := checkHTTPAssertion(a, resp, body, elapsed)
+		transcript.Assertions = append(transcript.Assertions, res)
+		if !res.Passed {
+			failures = append(failures, res.Message)
+		}
+	}
+
+	if len(failures) > 0 {
+		return transcriptResult(StatusFailed, strings.Join(failures, "; "), transcript), nil
+	}
+	return transcriptResult(StatusPassed, "", transcript), nil
+}
+
+func buildHTTPRequest(ctx context.Context, s httpSpec) (httpRequestLog, *http.Request, error) {
+	vars := s.Variables
+
+	method := strings.ToUpper(Interpolate(s.Request.Method, vars))
+	if method == "" {
+		method = http.MethodGet
+	}
+	reqLog := httpRequestLog{
+		Method:  method,
+		URL:     Interpolate(s.Request.URL, vars),
+		Headers: make(map[string]string, len(s.Request.Headers)),
+	}
+	for k, v := range s.Request.Headers {
+		reqLog.Headers[k] = Interpolate(v, vars)
+	}
+
+	isJSONBody := false
+	if len(s.Request.Body) > 0 && string(s.Request.Body) != "null" {
+		var text string
+		if err := json.Unmarshal(s.Request.Body, &text); err == nil {
+			reqLog.Body = Interpolate(text, vars)
+		} else {
+			reqLog.Body = Interpolate(string(s.Request.Body), vars)
+			isJSONBody = true
+		}
+	}
+
+	var body io.Reader
+	if reqLog.Body != "" {
+		body = bytes.NewBufferString(reqLog.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqLog.URL, body)
+	if err != nil {
+		return reqLog, nil, fmt.Errorf("invalid request: %w", err)
+	}
+	for k, v := range reqLog.Headers {
+		req.Header.Set(k, v)
+	}
+	if isJSONBody && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return reqLog, req, nil
+}
+
+func checkHTTPAssertion(a httpAssertion, resp *http.Response, body []byte, elapsed time.Duration) assertionResult {
+	res := assertionResult{httpAssertion: a}
+	fail := func(format string, args ...interface{}) assertionResult {
+		res.Message = fmt.Sprintf(format, args...)
+		return res
+	}
+
+	switch a.Type {
+	case "status":
+		res.Actual = resp.StatusCode
+		var want int
+		if err := json.Unmarshal(a.Equals, &want); err != nil {
+			return fail("status assertion needs a numeric equals")
+		}
+		if resp.StatusCode != want {
+			return fail("expected status %d, got %d", want, resp.StatusCode)
+		}
+	case "jsonpath":
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return fail("response body is not JSON: %v", err)
+		}
+		actual, err := lookupPath(doc, a.Path)
+		if err != nil {
+			return fail("%v", err)
+		}
+		res.Actual = actual
+		if msg := compareValue(a.Path, actual, a.Equals, a.Contains); msg != "" {
+			return fail("%s", msg)
+		}
+	case "header":
+		actual := resp.Header.Get(a.Name)
+		res.Actual = actual
+		if len(a.Equals) > 0 {
+			var want string
+			if err := json.Unmarshal(a.Equals, &want); err != nil {
+				return fail("header assertion needs a string equals")
+			}
+			if actual != want {
+				return fail("header %s: expected %q, got %q", a.Name, want, actual)
+			}
+		}
+		if a.Matches != "" {
+			re, err := regexp.Compile(a.Matches)
+			if err != nil {
+				return fail("invalid regexp %q: %v", a.Matches, err)
+			}
+			if !re.MatchString(actual) {
+				return fail("header %s: %q does not match %q", a.Name, actual, a.Matches)
+			}
+		}
+		if len(a.Contains) > 0 {
+			var want string
+			if err := json.Unmarshal(a.Contains, &want); err != nil {
+				return fail("header assertion needs a string contains")
+			}
+			if !strings.Contains(actual, want) {
+				return fail("header %s: %q does not contain %q", a.Name, actual, want)
+			}
+		}
+	case "response_time":
+		res.Actual = elapsed.Milliseconds()
+		if elapsed.Milliseconds() >= a.UnderMs {
+			return fail("response took %dms, expected under %dms", elapsed.Milliseconds(), a.UnderMs)
+		}
+	default:
+		return fail("unknown assertion type %q", a.Type)
+	}
+
+	res.Passed = true
+	return res
+}
+
+// compareValue checks actual against the optional equals and contains
+// expectations and returns a failure message, or "" if both hold.
+func compareValue(what string, actual interface{}, equals, contains json.RawMessage) string {
+	if len(equals) > 0 {
+		var want interface{}
+		if err := json.Unmarshal(equals, &want); err != nil {
+			return fmt.Sprintf("%s: invalid equals: %v", what, err)
+		}
+		if !reflect.DeepEqual(actual, want) {
+			return fmt.Sprintf("%s: expected %v, got %v", what, want, actual)
+		}
+	}
+
+	if len(contains) > 0 {
+		var want interface{}
+		if err := json.Unmarshal(contains, &want); err != nil {
+			return fmt.Sprintf("%s: invalid contains: %v", what, err)
+		}
+		if !containsValue(actual, want) {
+			return fmt.Sprintf("%s: %v does not contain %v", what, actual, want)
+		}
+	}
+
+	return ""
+}
+
+func containsValue(haystack, needle interface{}) bool {
+	switch h := haystack.(type) {
+	case string:
+		n, ok := needle.(string)
+		return ok && strings.Contains(h, n)
+	case []interface{}:
+		for _, v := range h {
+			if reflect.DeepEqual(v, needle) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		n, ok := needle.(string)
+		if ok {
+			_, found := h[n]
+			return found
+		}
+	}
+	return false
+}
+
+func transcriptResult(status, message string, transcript interface{}) Result {
+	output, _ := json.Marshal(transcript)
+	return Result{Status: status, Message: message, Output: output}
+}
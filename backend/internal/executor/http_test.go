@@ -0,0 +1,143 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newUserServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/42" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Request-Id", "req-"+r.Header.Get("X-Trace"))
+		fmt.Fprintf(w, `{"id":42,"name":"Ada","tags":["admin","dev"],"echo":%q}`, string(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func runHTTP(t *testing.T, spec string) (Result, httpTranscript) {
+	t.Helper()
+	res, err := HTTP{}.Execute(context.Background(), json.RawMessage(spec))
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	var transcript httpTranscript
+	if err := json.Unmarshal(res.Output, &transcript); err != nil {
+		t.Fatalf("transcript: %v", err)
+	}
+	return res, transcript
+}
+
+func TestHTTPAssertionsPass(t *testing.T) {
+	srv := newUserServer(t)
+	spec := fmt.Sprintf(`{
+		"type": "http",
+		"variables": {"id": "42"},
+		"request": {"method": "post", "url": "%s/users/{{id}}", "headers": {"X-Trace": "abc"}, "body": "hello"},
+		"assertions": [
+			{"type": "status", "equals": 200},
+			{"type": "header", "name": "Content-Type", "contains": "application/json"},
+			{"type": "header", "name": "X-Request-Id", "equals": "req-abc"},
+			{"type": "header", "name": "X-Request-Id", "matches": "^req-[a-z]+$"},
+			{"type": "jsonpath", "path": "$.name", "equals": "Ada"},
+			{"type": "jsonpath", "path": "$.tags[1]", "equals": "dev"},
+			{"type": "jsonpath", "path": "$.tags", "contains": "admin"},
+			{"type": "jsonpath", "path": "$.echo", "equals": "hello"},
+			{"type": "response_time", "under_ms": 5000}
+		]
+	}`, srv.URL)
+
+	res, transcript := runHTTP(t, spec)
+	if res.Status != StatusPassed {
+		t.Fatalf("status = %s, message %q", res.Status, res.Message)
+	}
+	if transcript.Request.Method != http.MethodPost || !strings.HasSuffix(transcript.Request.URL, "/users/42") {
+		t.Errorf("request log = %+v", transcript.Request)
+	}
+	if transcript.Response == nil || transcript.Response.Status != 200 {
+		t.Fatalf("response log = %+v", transcript.Response)
+	}
+	if len(transcript.Assertions) != 9 {
+		t.Errorf("got %d assertion results, want 9", len(transcript.Assertions))
+	}
+}
+
+func TestHTTPAssertionsFail(t *testing.T) {
+	srv := newUserServer(t)
+
+	tests := []struct {
+		name      string
+		assertion string
+		message   string
+	}{
+		{"status", `{"type": "status", "equals": 201}`, "expected status 201, got 200"},
+		{"header equals", `{"type": "header", "name": "X-Request-Id", "equals": "other"}`, `header X-Request-Id: expected "other"`},
+		{"header matches", `{"type": "header", "name": "X-Request-Id", "matches": "^\\d+$"}`, "does not match"},
+		{"jsonpath equals", `{"type": "jsonpath", "path": "$.id", "equals": 7}`, "$.id: expected 7, got 42"},
+		{"jsonpath missing", `{"type": "jsonpath", "path": "$.missing", "equals": 1}`, `key "missing" not found`},
+		{"jsonpath contains", `{"type": "jsonpath", "path": "$.tags", "contains": "root"}`, "does not contain root"},
+		{"unknown", `{"type": "nope"}`, `unknown assertion type "nope"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := fmt.Sprintf(`{"type": "http", "request": {"url": "%s/users/42"}, "assertions": [%s]}`, srv.URL, tt.assertion)
+			res, transcript := runHTTP(t, spec)
+			if res.Status != StatusFailed {
+				t.Fatalf("status = %s, want failed", res.Status)
+			}
+			if !strings.Contains(res.Message, tt.message) {
+				t.Errorf("message = %q, want it to contain %q", res.Message, tt.message)
+			}
+			if len(transcript.Assertions) != 1 || transcript.Assertions[0].Passed {
+				t.Errorf("assertion results = %+v", transcript.Assertions)
+			}
+		})
+	}
+}
+
+func TestHTTPTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	res, transcript := runHTTP(t, fmt.Sprintf(`{"type": "http", "request": {"url": "%s"}, "assertions": [{"type": "status", "equals": 200}]}`, url))
+	if res.Status != StatusError {
+		t.Fatalf("status = %s, want error", res.Status)
+	}
+	if !strings.Contains(res.Message, "request failed") {
+		t.Errorf("message = %q", res.Message)
+	}
+	if transcript.Response != nil {
+		t.Errorf("unexpected response log %+v", transcript.Response)
+	}
+}
+
+func TestHTTPTruncatesBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer srv.Close()
+
+	res, err := HTTP{MaxBodyBytes: 10}.Execute(context.Background(), json.RawMessage(fmt.Sprintf(`{"request": {"url": %q}}`, srv.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcript httpTranscript
+	json.Unmarshal(res.Output, &transcript)
+	if transcript.Response == nil || !transcript.Response.Truncated || len(transcript.Response.Body) != 10 {
+		t.Errorf("response log = %+v", transcript.Response)
+	}
+}
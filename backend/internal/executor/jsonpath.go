@@ -0,0 +1,77 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookupPath resolves a small JSONPath subset: $.a.b, $.items[0].name and
+// $['key with spaces'].
+func lookupPath(doc interface{}, path string) (interface{}, error) {
+	p := strings.TrimSpace(path)
+	if !strings.HasPrefix(p, "$") {
+		return nil, fmt.Errorf("path %q must start with $", path)
+	}
+	p = p[1:]
+
+	cur := doc
+	for len(p) > 0 {
+		switch {
+		case p[0] == '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			key := p[:end]
+			p = p[end:]
+
+			obj, ok := cur.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q: %q is not an object", path, key)
+			}
+			if cur, ok = obj[key]; !ok {
+				return nil, fmt.Errorf("%q: key %q not found", path, key)
+			}
+		case p[0] == '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%q: unterminated [", path)
+			}
+			sel := p[1:end]
+			p = p[end+1:]
+
+			if len(sel) >= 2 && (sel[0] == '\'' || sel[0] == '"') {
+				key := sel[1 : len(sel)-1]
+				obj, ok := cur.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("%q: %q is not an object", path, key)
+				}
+				if cur, ok = obj[key]; !ok {
+					return nil, fmt.Errorf("%q: key %q not found", path, key)
+				}
+				continue
+			}
+
+			idx, err := strconv.Atoi(sel)
+			if err != nil {
+				return nil, fmt.Errorf("%q: invalid index %q", path, sel)
+			}
+			arr, ok := cur.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%q: not an array", path)
+			}
+			if idx < 0 {
+				idx += len(arr)
+			}
+			if idx < 0 || idx >= len(arr) {
+				return nil, fmt.Errorf("%q: index %d out of range", path, idx)
+			}
+			cur = arr[idx]
+		default:
+			return nil, fmt.Errorf("%q: unexpected %q", path, p[0])
+		}
+	}
+	return cur, nil
+}
@@ -0,0 +1,135 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Policy controls how a single test case is attempted. Backoff is doubled
+// after every failed attempt, up to MaxBackoff.
+type Policy struct {
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+const (
+	// MaxAttemptsLimit is the most attempts a spec may ask for.
+	MaxAttemptsLimit = 10
+	MaxBackoff       = 5 * time.Minute
+)
+
+type policySpec struct {
+	TimeoutMs *int64 `json:"timeout_ms"`
+	Retry     *struct {
+		MaxAttempts *int   `json:"max_attempts"`
+		BackoffMs   *int64 `json:"backoff_ms"`
+	} `json:"retry"`
+}
+
+// ValidatePolicy checks the "timeout_ms" and "retry" fields of a spec.
+func ValidatePolicy(spec json.RawMessage) error {
+	var s policySpec
+	if len(spec) == 0 || json.Unmarshal(spec, &s) != nil {
+		return nil
+	}
+
+	if s.TimeoutMs != nil && *s.TimeoutMs < 0 {
+		return fmt.Errorf("timeout_ms must not be negative")
+	}
+	if s.Retry != nil {
+		if n := s.Retry.MaxAttempts; n != nil && (*n < 1 || *n > MaxAttemptsLimit) {
+			return fmt.Errorf("retry.max_attempts must be between 1 and %d", MaxAttemptsLimit)
+		}
+		if s.Retry.BackoffMs != nil && *s.Retry.BackoffMs < 0 {
+			return fmt.Errorf("retry.backoff_ms must not be negative")
+		}
+	}
+	return nil
+}
+
+// PolicyFor returns defaults overridden by the "timeout_ms" and "retry"
+// fields of the spec. Out of range values are clamped.
+func PolicyFor(spec json.RawMessage, defaults Policy) Policy {
+	p := defaults
+
+	var s policySpec
+	if len(spec) == 0 || json.Unmarshal(spec, &s) != nil {
+		return p
+	}
+
+	if s.TimeoutMs != nil {
+		p.Timeout = time.Duration(*s.TimeoutMs) * time.Millisecond
+	}
+	if s.Retry != nil {
+		if s.Retry.MaxAttempts != nil {
+			p.MaxAttempts = *s.Retry.MaxAttempts
+		}
+		if s.Retry.BackoffMs != nil {
+			p.Backoff = time.Duration(*s.Retry.BackoffMs) * time.Millisecond
+		}
+	}
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	if p.MaxAttempts > MaxAttemptsLimit {
+		p.MaxAttempts = MaxAttemptsLimit
+	}
+	if p.Backoff < 0 {
+		p.Backoff = 0
+	}
+	return p
+}
+
+// delay returns the wait after the given failed attempt.
+func (p Policy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+	return d
+}
+
+// RunWithPolicy attempts the spec until it passes, attempts are exhausted or
+// ctx is cancelled. Every attempt is returned; the last one is the outcome.
+func (r *Registry) RunWithPolicy(ctx context.Context, spec json.RawMessage, p Policy) []Result {
+	var attempts []Result
+	for attempt := 1; ; attempt++ {
+		res := r.runAttempt(ctx, spec, p.Timeout)
+		attempts = append(attempts, res)
+
+		if res.Status == StatusPassed || res.Status == StatusSkipped || attempt >= p.MaxAttempts {
+			return attempts
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempts
+		case <-time.After(p.delay(attempt)):
+		}
+	}
+}
+
+func (r *Registry) runAttempt(ctx context.Context, spec json.RawMessage, timeout time.Duration) Result {
+	attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	res := r.Run(attemptCtx, spec)
+	switch {
+	case ctx.Err() != nil:
+		res.Status = StatusSkipped
+		res.Message = "cancelled"
+	case attemptCtx.Err() == context.DeadlineExceeded:
+		res.Status = StatusError
+		res.Message = fmt.Sprintf("timed out after %s", timeout)
+	}
+	return res
+}
@@ -0,0 +1,51 @@
+package executor
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelayIsCapped(t *testing.T) {
+	p := Policy{Backoff: time.Second, MaxAttempts: MaxAttemptsLimit}
+	if d := p.delay(1); d != time.Second {
+		t.Errorf("delay(1) = %s, want 1s", d)
+	}
+	if d := p.delay(3); d != 4*time.Second {
+		t.Errorf("delay(3) = %s, want 4s", d)
+	}
+	for _, attempt := range []int{20, 64, 100, 1 << 20} {
+		if d := p.delay(attempt); d != MaxBackoff {
+			t.Errorf("delay(%d) = %s, want %s", attempt, d, MaxBackoff)
+		}
+	}
+}
+
+func TestPolicyForClampsAttempts(t *testing.T) {
+	p := PolicyFor(json.RawMessage(`{"retry":{"max_attempts":1000,"backoff_ms":-5}}`), Policy{MaxAttempts: 1})
+	if p.MaxAttempts != MaxAttemptsLimit {
+		t.Errorf("MaxAttempts = %d, want %d", p.MaxAttempts, MaxAttemptsLimit)
+	}
+	if p.Backoff != 0 {
+		t.Errorf("Backoff = %s, want 0", p.Backoff)
+	}
+}
+
+func TestValidatePolicy(t *testing.T) {
+	tests := []struct {
+		spec string
+		ok   bool
+	}{
+		{`{"type":"noop"}`, true},
+		{`{"retry":{"max_attempts":3,"backoff_ms":100},"timeout_ms":500}`, true},
+		{`{"retry":{"max_attempts":0}}`, false},
+		{`{"retry":{"max_attempts":11}}`, false},
+		{`{"retry":{"backoff_ms":-1}}`, false},
+		{`{"timeout_ms":-1}`, false},
+	}
+	for _, tt := range tests {
+		if err := ValidatePolicy(json.RawMessage(tt.spec)); (err == nil) != tt.ok {
+			t.Errorf("ValidatePolicy(%s) = %v, want ok=%v", tt.spec, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultMaxOutputBytes = 64 << 10
+
+// Shell runs a command and checks its exit code and output. Only commands in
+// AllowedCommands may run; an empty list allows none. The command gets a
+// minimal environment (PATH plus the spec env, which may not change PATH or
+// the dynamic loader), runs inside WorkDir, its output is capped at
+// MaxOutputBytes per stream and it is killed after Timeout.
+type Shell struct {
+	AllowedCommands []string
+	WorkDir         string
+	MaxOutputBytes  int64
+	Timeout         time.Duration
+}
+
+type shellSpec struct {
+	Argv       []string          `json:"argv"`
+	Env        map[string]string `json:"env"`
+	Dir        string            `json:"dir"`
+	Stdin      string            `json:"stdin"`
+	Assertions []shellAssertion  `json:"assertions"`
+}
+
+type shellAssertion struct {
+	Type       string `json:"type"`
+	Equals     *int   `json:"equals,omitempty"`
+	Matches    string `json:"matches,omitempty"`
+	NotMatches string `json:"not_matches,omitempty"`
+}
+
+type shellAssertionResult struct {
+	shellAssertion
+	Passed  bool   `json:"passed"`
+	Message string `json:"message,omitempty"`
+}
+
+type shellTranscript struct {
+	Argv            []string               `json:"argv"`
+	Dir             string                 `json:"dir,omitempty"`
+	ExitCode        int                    `json:"exit_code"`
+	Stdout          string                 `json:"stdout"`
+	Stderr          string                 `json:"stderr"`
+	StdoutTruncated bool                   `json:"stdout_truncated,omitempty"`
+	StderrTruncated bool                   `json:"stderr_truncated,omitempty"`
+	DurationMs      int64                  `json:"duration_ms"`
+	Assertions      []shellAssertionResult `json:"assertions"`
+}
+
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	room := b.max - int64(b.buf.Len())
+	if int64(len(p)) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (e Shell) Execute(ctx context.Context, spec json.RawMessage) (Result, error) {
+	var s shellSpec
+	if err := json.Unmarshal(spec, &s); err != nil {
+		return Result{}, fmt.Errorf("invalid shell spec: %w", err)
+	}
+	if len(s.Argv) == 0 {
+		return Result{}, fmt.Errorf("shell spec has no argv")
+	}
+	if !e.allowed(s.Argv[0]) {
+		return Result{}, fmt.Errorf("command %q is not allowed", s.Argv[0])
+	}
+	for k := range s.Env {
+		if !allowedEnvKey(k) {
+			return Result{}, fmt.Errorf("env %q may not be set", k)
+		}
+	}
+	dir, err := e.workDir(s.Dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if e.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.Timeout)
+		defer cancel()
+	}
+
+	maxOutput := e.MaxOutputBytes
+	if maxOutput <= 0 {
+		maxOutput = defaultMaxOutputBytes
+	}
+	stdout := &cappedBuffer{max: maxOutput}
+	stderr := &cappedBuffer{max: maxOutput}
+
+	cmd := exec.CommandContext(ctx, s.Argv[0], s.Argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	for k, v := range s.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin = strings.NewReader(s.Stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Do not wait forever for children that inherited the output pipes.
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+
+	transcript := shellTranscript{
+		Argv:            s.Argv,
+		Dir:             dir,
+		ExitCode:        cmd.ProcessState.ExitCode(),
+		Stdout:          stdout.buf.String(),
+		Stderr:          stderr.buf.String(),
+		StdoutTruncated: stdout.truncated,
+		StderrTruncated: stderr.truncated,
+		DurationMs:      time.Since(start).Milliseconds(),
+		Assertions:      []shellAssertionResult{},
+	}
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return transcriptResult(StatusError, fmt.Sprintf("command killed: %v", ctxErr), transcript), nil
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return transcriptResult(StatusError, fmt.Sprintf("command failed to run: %v", err), transcript), nil
+	}
+
+	var failures []string
+	for _, a := range s.Assertions {
+		res := checkShellAssertion(a, transcript)
+		transcript.Assertions = append(transcript.Assertions, res)
+		if !res.Passed {
+			failures = append(failures, res.Message)
+		}
+	}
+
+	if len(failures) > 0 {
+		return transcriptResult(StatusFailed, strings.Join(failures, "; "), transcript), nil
+	}
+	return transcriptResult(StatusPassed, "", transcript), nil
+}
+
+func (e Shell) allowed(command string) bool {
+	for _, c := range e.AllowedCommands {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+func allowedEnvKey(key string) bool {
+	k := strings.ToUpper(key)
+	return k != "" && k != "PATH" && !strings.HasPrefix(k, "LD_") && !strings.ContainsAny(k, "=\x00")
+}
+
+// workDir resolves the spec dir, which is relative to WorkDir and may not
+// leave it, symlinks included. Without a WorkDir no dir may be given.
+func (e Shell) workDir(dir string) (string, error) {
+	if e.WorkDir == "" {
+		if dir != "" {
+			return "", fmt.Errorf("dir is not allowed without a configured work dir")
+		}
+		return "", nil
+	}
+	if filepath.IsAbs(dir) {
+		return "", fmt.Errorf("dir %q must be relative to the work dir", dir)
+	}
+
+	root, err := filepath.EvalSymlinks(e.WorkDir)
+	if err != nil {
+		return "", fmt.Errorf("work dir: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, dir))
+	if err != nil {
+		return "", fmt.Errorf("dir %q: %w", dir, err)
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("dir %q is outside the work dir", dir)
+	}
+	return resolved, nil
+}
+
+func checkShellAssertion(a shellAssertion, t shellTranscript) shellAssertionResult {
+	res := shellAssertionResult{shellAssertion: a}
+	fail := func(format string, args ...interface{}) shellAssertionResult {
+		res.Message = fmt.Sprintf(format, args...)
+		return res
+	}
+
+	switch a.Type {
+	case "exit_code":
+		if a.Equals == nil {
+			return fail("exit_code assertion needs equals")
+		}
+		if t.ExitCode != *a.Equals {
+			return fail("expected exit code %d, got %d", *a.Equals, t.ExitCode)
+		}
+	case "stdout", "stderr":
+		output := t.Stdout
+		if a.Type == "stderr" {
+			output = t.Stderr
+		}
+		if a.Matches != "" {
+			re, err := regexp.Compile(a.Matches)
+			if err != nil {
+				return fail("invalid regexp %q: %v", a.Matches, err)
+			}
+			if !re.MatchString(output) {
+				return fail("%s does not match %q", a.Type, a.Matches)
+			}
+		}
+		if a.NotMatches != "" {
+			re, err := regexp.Compile(a.NotMatches)
+			if err != nil {
+				return fail("invalid regexp %q: %v", a.NotMatches, err)
+			}
+			if re.MatchString(output) {
+				return fail("%s matches %q", a.Type, a.NotMatches)
+			}
+		}
+	default:
+		return fail("unknown assertion type %q", a.Type)
+	}
+
+	res.Passed = true
+	return res
+}
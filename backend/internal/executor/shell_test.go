@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShellRejectsUnsafeSpecs(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/", filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	e := Shell{AllowedCommands: []string{"echo"}, WorkDir: root}
+
+	tests := []struct {
+		name    string
+		shell   Shell
+		spec    string
+		message string
+	}{
+		{"empty allowlist", Shell{}, `{"argv":["echo","hi"]}`, `command "echo" is not allowed`},
+		{"not allowed", e, `{"argv":["sh","-c","id"]}`, `command "sh" is not allowed`},
+		{"PATH override", e, `{"argv":["echo"],"env":{"PATH":"/tmp"}}`, `env "PATH" may not be set`},
+		{"lowercase path", e, `{"argv":["echo"],"env":{"path":"/tmp"}}`, `env "path" may not be set`},
+		{"loader override", e, `{"argv":["echo"],"env":{"LD_PRELOAD":"/tmp/x.so"}}`, `env "LD_PRELOAD" may not be set`},
+		{"absolute dir", e, `{"argv":["echo"],"dir":"/etc"}`, "must be relative"},
+		{"parent dir", e, `{"argv":["echo"],"dir":"../.."}`, "outside the work dir"},
+		{"symlink dir", e, `{"argv":["echo"],"dir":"escape"}`, "outside the work dir"},
+		{"dir without work dir", Shell{AllowedCommands: []string{"echo"}}, `{"argv":["echo"],"dir":"sub"}`, "without a configured work dir"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.shell.Execute(context.Background(), json.RawMessage(tt.spec))
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Fatalf("err = %v, want it to contain %q", err, tt.message)
+			}
+		})
+	}
+}
+
+func TestShellRunsInWorkDir(t *testing.T) {
+	if _, err := os.Stat("/bin/pwd"); err != nil {
+		t.Skip("/bin/pwd not available")
+	}
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	e := Shell{AllowedCommands: []string{"/bin/pwd"}, WorkDir: root}
+
+	res, err := e.Execute(context.Background(), json.RawMessage(`{
+		"argv": ["/bin/pwd"],
+		"dir": "sub",
+		"env": {"GREETING": "hi"},
+		"assertions": [{"type": "exit_code", "equals": 0}, {"type": "stdout", "matches": "/sub\\n$"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusPassed {
+		t.Fatalf("status = %s, message %q, output %s", res.Status, res.Message, res.Output)
+	}
+}
@@ -0,0 +1,271 @@
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	_ "github.com/lib/pq"
+)
+
+const defaultMaxRows = 1000
+
+// SQL runs a query against one of the configured datasources and checks the
+// rows it returns. Queries always run in a read-only transaction that is
+// rolled back, so a test case can never modify the database it inspects.
+// Datasources must be configured explicitly; there is no implicit default.
+type SQL struct {
+	MaxRows int
+
+	dsns map[string]string
+
+	mu  sync.Mutex
+	dbs map[string]*sql.DB
+}
+
+func NewSQL(dsns map[string]string) *SQL {
+	return &SQL{dsns: dsns, dbs: make(map[string]*sql.DB)}
+}
+
+type sqlSpec struct {
+	Datasource string         `json:"datasource"`
+	Query      string         `json:"query"`
+	Args       []interface{}  `json:"args"`
+	Assertions []sqlAssertion `json:"assertions"`
+}
+
+type sqlAssertion struct {
+	Type     string          `json:"type"`
+	Row      int             `json:"row,omitempty"`
+	Column   string          `json:"column,omitempty"`
+	Equals   json.RawMessage `json:"equals,omitempty"`
+	Contains json.RawMessage `json:"contains,omitempty"`
+	Ordered  *bool           `json:"ordered,omitempty"`
+}
+
+type sqlAssertionResult struct {
+	sqlAssertion
+	Actual  interface{} `json:"actual,omitempty"`
+	Passed  bool        `json:"passed"`
+	Message string      `json:"message,omitempty"`
+}
+
+type sqlTranscript struct {
+	Datasource string                   `json:"datasource"`
+	Query      string                   `json:"query"`
+	Columns    []string                 `json:"columns,omitempty"`
+	Rows       []map[string]interface{} `json:"rows,omitempty"`
+	RowCount   int                      `json:"row_count"`
+	Truncated  bool                     `json:"truncated,omitempty"`
+	Assertions []sqlAssertionResult     `json:"assertions"`
+}
+
+func (e *SQL) Execute(ctx context.Context, spec json.RawMessage) (Result, error) {
+	var s sqlSpec
+	if err := json.Unmarshal(spec, &s); err != nil {
+		return Result{}, fmt.Errorf("invalid sql spec: %w", err)
+	}
+	if s.Query == "" {
+		return Result{}, fmt.Errorf("sql spec has no query")
+	}
+	if s.Datasource == "" {
+		return Result{}, fmt.Errorf("sql spec has no datasource")
+	}
+
+	db, err := e.open(s.Datasource)
+	if err != nil {
+		return Result{}, err
+	}
+
+	transcript := sqlTranscript{Datasource: s.Datasource, Query: s.Query, Assertions: []sqlAssertionResult{}}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return Result{}, fmt.Errorf("datasource %q: %w", s.Datasource, err)
+	}
+	defer tx.Rollback()
+
+	maxRows := e.MaxRows
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
+	if err := readRows(ctx, tx, s, maxRows, &transcript); err != nil {
+		return transcriptResult(StatusFailed, fmt.Sprintf("query failed: %v", err), transcript), nil
+	}
+
+	var failures []string
+	for _, a := range s.Assertions {
+		res := checkSQLAssertion(a, transcript)
+		transcript.Assertions = append(transcript.Assertions, res)
+		if !res.Passed {
+			failures = append(failures, res.Message)
+		}
+	}
+
+	if len(failures) > 0 {
+		return transcriptResult(StatusFailed, strings.Join(failures, "; "), transcript), nil
+	}
+	return transcriptResult(StatusPassed, "", transcript), nil
+}
+
+func (e *SQL) open(name string) (*sql.DB, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if db, ok := e.dbs[name]; ok {
+		return db, nil
+	}
+
+	dsn, ok := e.dsns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown datasource %q", name)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("datasource %q: %w", name, err)
+	}
+	e.dbs[name] = db
+	return db, nil
+}
+
+// readRows always prepares the query so that it goes through the extended
+// protocol, which rejects several statements in one query. Otherwise a query
+// without args could end the read-only transaction with its own COMMIT.
+func readRows(ctx context.Context, tx *sql.Tx, s sqlSpec, maxRows int, t *sqlTranscript) error {
+	stmt, err := tx.PrepareContext(ctx, s.Query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, s.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if t.Columns, err = rows.Columns(); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(t.Columns))
+	ptrs := make([]interface{}, len(t.Columns))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+
+	for rows.Next() {
+		t.RowCount++
+		if len(t.Rows) >= maxRows {
+			t.Truncated = true
+			continue
+		}
+
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		row := make(map[string]interface{}, len(t.Columns))
+		for i, col := range t.Columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		t.Rows = append(t.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Round-trip through JSON so that row values compare equal to the JSON
+	// decoded expectations (numbers as float64, timestamps as strings).
+	data, err := json.Marshal(t.Rows)
+	if err != nil {
+		return err
+	}
+	t.Rows = nil
+	return json.Unmarshal(data, &t.Rows)
+}
+
+func checkSQLAssertion(a sqlAssertion, t sqlTranscript) sqlAssertionResult {
+	res := sqlAssertionResult{sqlAssertion: a}
+	fail := func(format string, args ...interface{}) sqlAssertionResult {
+		res.Message = fmt.Sprintf(format, args...)
+		return res
+	}
+
+	switch a.Type {
+	case "row_count":
+		res.Actual = t.RowCount
+		var want int
+		if err := json.Unmarshal(a.Equals, &want); err != nil {
+			return fail("row_count assertion needs a numeric equals")
+		}
+		if t.RowCount != want {
+			return fail("expected %d rows, got %d", want, t.RowCount)
+		}
+	case "column":
+		if a.Row < 0 || a.Row >= len(t.Rows) {
+			return fail("row %d not found, query returned %d rows", a.Row, t.RowCount)
+		}
+		actual, ok := t.Rows[a.Row][a.Column]
+		if !ok {
+			return fail("column %q not found", a.Column)
+		}
+		res.Actual = actual
+		if msg := compareValue(fmt.Sprintf("row %d column %s", a.Row, a.Column), actual, a.Equals, a.Contains); msg != "" {
+			return fail("%s", msg)
+		}
+	case "result_set":
+		if t.Truncated {
+			return fail("result set has more than %d rows", len(t.Rows))
+		}
+		var want []map[string]interface{}
+		if err := json.Unmarshal(a.Equals, &want); err != nil {
+			return fail("result_set assertion needs an array of rows in equals")
+		}
+		ordered := a.Ordered == nil || *a.Ordered
+		if !sameRows(t.Rows, want, ordered) {
+			return fail("result set does not match expected rows")
+		}
+	default:
+		return fail("unknown assertion type %q", a.Type)
+	}
+
+	res.Passed = true
+	return res
+}
+
+func sameRows(actual, want []map[string]interface{}, ordered bool) bool {
+	if len(actual) != len(want) {
+		return false
+	}
+	if ordered {
+		for i := range want {
+			if !reflect.DeepEqual(actual[i], want[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	used := make([]bool, len(actual))
+	for _, w := range want {
+		found := false
+		for i, a := range actual {
+			if !used[i] && reflect.DeepEqual(a, w) {
+				used[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,26 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSQLRequiresConfiguredDatasource(t *testing.T) {
+	e := NewSQL(map[string]string{})
+
+	tests := []struct {
+		spec    string
+		message string
+	}{
+		{`{"type":"sql","query":"SELECT 1"}`, "no datasource"},
+		{`{"type":"sql","datasource":"default","query":"SELECT 1"}`, `unknown datasource "default"`},
+	}
+	for _, tt := range tests {
+		_, err := e.Execute(context.Background(), json.RawMessage(tt.spec))
+		if err == nil || !strings.Contains(err.Error(), tt.message) {
+			t.Errorf("Execute(%s) = %v, want error containing %q", tt.spec, err, tt.message)
+		}
+	}
+}
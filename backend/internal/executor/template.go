@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.\-]+)\s*\}\}`)
+
+// Interpolate replaces {{name}} placeholders with values from vars. Unknown
+// placeholders are left untouched so that they stay visible in transcripts.
+func Interpolate(s string, vars map[string]string) string {
+	if len(vars) == 0 || !strings.Contains(s, "{{") {
+		return s
+	}
+
+	return placeholderRe.ReplaceAllStringFunc(s, func(m string) string {
+		name := placeholderRe.FindStringSubmatch(m)[1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// InterpolateJSON substitutes placeholders in every string of a JSON document.
+// A string that consists of a single placeholder takes the variable's value
+// as is, so {"equals": "{{code}}"} with code=200 becomes {"equals": 200}.
+func InterpolateJSON(spec json.RawMessage, vars map[string]interface{}) (json.RawMessage, error) {
+	if len(vars) == 0 || len(spec) == 0 {
+		return spec, nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, err
+	}
+
+	text := make(map[string]string, len(vars))
+	for k, v := range vars {
+		text[k] = stringify(v)
+	}
+
+	return json.Marshal(interpolateNode(doc, vars, text))
+}
+
+func interpolateNode(node interface{}, vars map[string]interface{}, text map[string]string) interface{} {
+	switch n := node.(type) {
+	case string:
+		if m := placeholderRe.FindStringSubmatchIndex(n); m != nil && m[0] == 0 && m[1] == len(n) {
+			if v, ok := vars[n[m[2]:m[3]]]; ok {
+				return v
+			}
+		}
+		return Interpolate(n, text)
+	case []interface{}:
+		for i := range n {
+			n[i] = interpolateNode(n[i], vars, text)
+		}
+	case map[string]interface{}:
+		for k := range n {
+			n[k] = interpolateNode(n[k], vars, text)
+		}
+	}
+	return node
+}
+
+func stringify(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case nil:
+		return ""
+	case float64:
+		// Avoid exponents so that 1000000 does not become 1e+06.
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case json.Number:
+		return t.String()
+	case bool, int, int64:
+		return fmt.Sprint(t)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
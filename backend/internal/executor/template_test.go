@@ -0,0 +1,41 @@
+package executor
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"id": "42", "name": "Ada"}
+	got := Interpolate("/users/{{id}}?q={{ name }}&x={{missing}}", vars)
+	if want := "/users/42?q=Ada&x={{missing}}"; got != want {
+		t.Errorf("Interpolate = %q, want %q", got, want)
+	}
+}
+
+func TestInterpolateJSONNumbers(t *testing.T) {
+	vars := map[string]interface{}{
+		"big":    float64(1000000),
+		"ratio":  0.25,
+		"number": json.Number("12345678901234567890"),
+	}
+	spec := json.RawMessage(`{"url": "http://host/items/{{big}}?r={{ratio}}&n={{number}}", "equals": "{{big}}"}`)
+
+	out, err := InterpolateJSON(spec, vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		URL    string          `json:"url"`
+		Equals json.RawMessage `json:"equals"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://host/items/1000000?r=0.25&n=12345678901234567890"; got.URL != want {
+		t.Errorf("url = %q, want %q", got.URL, want)
+	}
+	if string(got.Equals) != "1000000" {
+		t.Errorf("equals = %s, want 1000000", got.Equals)
+	}
+}
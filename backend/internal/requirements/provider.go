@@ -0,0 +1,81 @@
+package requirements
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Requirement is a requirement as seen by its owning system. ExternalID is
+// the identifier in that system and is what local copies are matched on.
+type Requirement struct {
+	ExternalID  string `json:"external_id"`
+	Key         string `json:"key"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+}
+
+// Provider is a system that owns requirements. project identifies the
+// project in the provider's own terms. Report writes a verification verdict
+// back and must tolerate receiving the same verdict twice.
+type Provider interface {
+	Name() string
+	List(ctx context.Context, project string) ([]Requirement, error)
+	Report(ctx context.Context, v Verdict) error
+}
+
+// Postgres serves the requirements stored in the backend's own database.
+type Postgres struct {
+	DB *sql.DB
+}
+
+func (Postgres) Name() string { return "native" }
+
+func (p Postgres) List(ctx context.Context, project string) ([]Requirement, error) {
+	rows, err := p.DB.QueryContext(ctx, `
+		SELECT COALESCE(external_id, id::text), key, title, COALESCE(description, ''), status
+		FROM requirements WHERE project_id = $1
+		ORDER BY key
+	`, project)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reqs []Requirement
+	for rows.Next() {
+		var req Requirement
+		if err := rows.Scan(&req.ExternalID, &req.Key, &req.Title, &req.Description, &req.Status); err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, rows.Err()
+}
+
+// Report stores the verdict on the requirement unless a later run has
+// already been recorded.
+func (p Postgres) Report(ctx context.Context, v Verdict) error {
+	res, err := p.DB.ExecContext(ctx, `
+		UPDATE requirements SET verification_status = $3, verified_run_id = $4, verified_at = $5
+		WHERE project_id::text = $1 AND COALESCE(external_id, id::text) = $2
+			AND (verified_at IS NULL OR verified_at <= $5)
+	`, v.Project, v.ExternalID, v.Verdict, v.RunID, v.VerifiedAt)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		err := p.DB.QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM requirements WHERE project_id::text = $1 AND COALESCE(external_id, id::text) = $2)
+		`, v.Project, v.ExternalID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("requirement %s not found", v.ExternalID)
+		}
+	}
+	return nil
+}
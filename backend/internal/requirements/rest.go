@@ -0,0 +1,186 @@
+package requirements
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Fields maps requirement fields to dotted paths inside the remote JSON.
+// Items locates the array of requirements in the list response; empty means
+// the response is the array itself.
+type Fields struct {
+	Items       string
+	ID          string
+	Key         string
+	Title       string
+	Description string
+	Status      string
+}
+
+// DefaultFields expects a bare array of objects using the local field names.
+var DefaultFields = Fields{
+	ID:          "id",
+	Key:         "key",
+	Title:       "title",
+	Description: "description",
+	Status:      "status",
+}
+
+// REST reads requirements from a generic JSON API.
+type REST struct {
+	Client   *http.Client
+	BaseURL  string
+	ListPath string // may contain {project}
+	// VerdictPath receives verdicts as POSTs; it may contain {project} and {id}.
+	VerdictPath string
+	AuthHeader  string
+	AuthToken   string
+	Fields      Fields
+	// Statuses translates remote status values to local ones.
+	Statuses map[string]string
+}
+
+func (REST) Name() string { return "rest" }
+
+func (p REST) List(ctx context.Context, project string) ([]Requirement, error) {
+	path := strings.ReplaceAll(p.ListPath, "{project}", url.PathEscape(project))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(p.BaseURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	p.authorize(req)
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("requirements provider returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	var body interface{}
+	if err := dec.Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid requirements response: %w", err)
+	}
+
+	items := body
+	if p.Fields.Items != "" {
+		items = lookup(body, p.Fields.Items)
+	}
+	list, ok := items.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("requirements response has no list at %q", p.Fields.Items)
+	}
+
+	reqs := make([]Requirement, 0, len(list))
+	for i, item := range list {
+		r := Requirement{
+			ExternalID:  field(item, p.Fields.ID),
+			Key:         field(item, p.Fields.Key),
+			Title:       field(item, p.Fields.Title),
+			Description: field(item, p.Fields.Description),
+			Status:      field(item, p.Fields.Status),
+		}
+		if r.ExternalID == "" {
+			return nil, fmt.Errorf("requirement %d has no id at %q", i, p.Fields.ID)
+		}
+		if s, ok := p.Statuses[r.Status]; ok {
+			r.Status = s
+		}
+		reqs = append(reqs, r)
+	}
+	return reqs, nil
+}
+
+func (p REST) Report(ctx context.Context, v Verdict) error {
+	if p.VerdictPath == "" {
+		return fmt.Errorf("requirements provider has no verdict path")
+	}
+	path := strings.NewReplacer(
+		"{project}", url.PathEscape(v.Project),
+		"{id}", url.PathEscape(v.ExternalID),
+	).Replace(p.VerdictPath)
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.BaseURL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", v.IdempotencyKey())
+	p.authorize(req)
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	// A conflict means the provider already has this verdict.
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusConflict {
+		return fmt.Errorf("requirements provider returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (p REST) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	return http.DefaultClient
+}
+
+func (p REST) authorize(req *http.Request) {
+	if p.AuthToken == "" {
+		return
+	}
+	header := p.AuthHeader
+	if header == "" {
+		header = "Authorization"
+	}
+	req.Header.Set(header, p.AuthToken)
+}
+
+// lookup follows a dotted path through nested objects.
+func lookup(v interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[part]
+	}
+	return v
+}
+
+func field(item interface{}, path string) string {
+	switch v := lookup(item, path).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,177 @@
+package requirements
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRESTList(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/projects/PRJ 1/issues" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-Token") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": {"issues": [
+			{"id": 101, "fields": {"key": "PRJ-1", "summary": "Login", "body": "Users can log in", "state": "Open"}},
+			{"id": "102", "fields": {"key": "PRJ-2", "summary": "Logout", "state": "Closed"}}
+		]}}`))
+	}))
+	defer srv.Close()
+
+	p := REST{
+		BaseURL:    srv.URL + "/",
+		ListPath:   "/api/projects/{project}/issues",
+		AuthHeader: "X-Token",
+		AuthToken:  "secret",
+		Fields: Fields{
+			Items:       "data.issues",
+			ID:          "id",
+			Key:         "fields.key",
+			Title:       "fields.summary",
+			Description: "fields.body",
+			Status:      "fields.state",
+		},
+		Statuses: map[string]string{"Open": "active", "Closed": "deprecated"},
+	}
+
+	reqs, err := p.List(context.Background(), "PRJ 1")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	want := []Requirement{
+		{ExternalID: "101", Key: "PRJ-1", Title: "Login", Description: "Users can log in", Status: "active"},
+		{ExternalID: "102", Key: "PRJ-2", Title: "Logout", Status: "deprecated"},
+	}
+	if len(reqs) != len(want) {
+		t.Fatalf("got %d requirements, want %d", len(reqs), len(want))
+	}
+	for i := range want {
+		if reqs[i] != want[i] {
+			t.Errorf("requirement %d = %+v, want %+v", i, reqs[i], want[i])
+		}
+	}
+}
+
+func TestRESTListErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		message string
+	}{
+		{"status", http.StatusInternalServerError, "boom", "500 Internal Server Error: boom"},
+		{"not json", http.StatusOK, "<html>", "invalid requirements response"},
+		{"no list", http.StatusOK, `{"items": {}}`, "has no list"},
+		{"no id", http.StatusOK, `[{"key": "A-1"}]`, "requirement 0 has no id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			fields := DefaultFields
+			if tt.name == "no list" {
+				fields.Items = "items"
+			}
+			_, err := REST{BaseURL: srv.URL, ListPath: "/reqs", Fields: fields}.List(context.Background(), "P")
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.message)
+			}
+		})
+	}
+}
+
+func TestRESTReport(t *testing.T) {
+	v := Verdict{
+		Project:    "PRJ",
+		ExternalID: "101",
+		Key:        "PRJ-1",
+		RunID:      "run-1",
+		Verdict:    VerdictVerified,
+		Total:      2,
+		Passed:     2,
+		VerifiedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusCreated, false},
+		{"already reported", http.StatusConflict, false},
+		{"rejected", http.StatusBadRequest, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Verdict
+			var path, idempotencyKey, contentType string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				idempotencyKey = r.Header.Get("Idempotency-Key")
+				contentType = r.Header.Get("Content-Type")
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			p := REST{BaseURL: srv.URL, VerdictPath: "/projects/{project}/requirements/{id}/verdicts"}
+			err := p.Report(context.Background(), v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Report err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if path != "/projects/PRJ/requirements/101/verdicts" {
+				t.Errorf("path = %q", path)
+			}
+			if idempotencyKey != "run-1:101" {
+				t.Errorf("Idempotency-Key = %q", idempotencyKey)
+			}
+			if contentType != "application/json" {
+				t.Errorf("Content-Type = %q", contentType)
+			}
+			if got != v {
+				t.Errorf("payload = %+v, want %+v", got, v)
+			}
+		})
+	}
+}
+
+func TestRESTReportWithoutPath(t *testing.T) {
+	if err := (REST{BaseURL: "http://example.invalid"}).Report(context.Background(), Verdict{}); err == nil {
+		t.Error("expected an error without a verdict path")
+	}
+}
+
+func TestDecide(t *testing.T) {
+	tests := []struct {
+		total, passed, failed int
+		want                  string
+	}{
+		{0, 0, 0, ""},
+		{2, 0, 0, ""},
+		{2, 2, 0, VerdictVerified},
+		{2, 0, 2, VerdictFailed},
+		{3, 1, 1, VerdictPartiallyVerified},
+		{3, 2, 0, VerdictPartiallyVerified},
+	}
+	for _, tt := range tests {
+		if got := Decide(tt.total, tt.passed, tt.failed); got != tt.want {
+			t.Errorf("Decide(%d, %d, %d) = %q, want %q", tt.total, tt.passed, tt.failed, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package requirements
+
+import "time"
+
+const (
+	VerdictVerified          = "verified"
+	VerdictFailed            = "failed"
+	VerdictPartiallyVerified = "partially_verified"
+)
+
+// Verdict is the outcome of one run for one requirement.
+type Verdict struct {
+	Project    string    `json:"project"`
+	ExternalID string    `json:"requirement_id"`
+	Key        string    `json:"requirement_key"`
+	RunID      string    `json:"run_id"`
+	Verdict    string    `json:"verdict"`
+	Total      int       `json:"total"`
+	Passed     int       `json:"passed"`
+	Failed     int       `json:"failed"`
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// IdempotencyKey identifies the verdict so that providers can drop repeats.
+func (v Verdict) IdempotencyKey() string {
+	return v.RunID + ":" + v.ExternalID
+}
+
+// Decide turns the results of a requirement's test cases into a verdict.
+// Cases that neither passed nor failed (skipped) carry no evidence, so a
+// requirement with only those gets no verdict at all.
+func Decide(total, passed, failed int) string {
+	switch {
+	case total == 0 || passed+failed == 0:
+		return ""
+	case passed == total:
+		return VerdictVerified
+	case passed == 0:
+		return VerdictFailed
+	default:
+		return VerdictPartiallyVerified
+	}
+}
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventRunFinished       = "run.finished"
+	EventCaseStatusChanged = "case.status_changed"
+
+	HeaderEvent     = "X-Zis-Event"
+	HeaderDelivery  = "X-Zis-Delivery"
+	HeaderSignature = "X-Zis-Signature"
+)
+
+type Event struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      string      `json:"type"`
+	ProjectID uuid.UUID   `json:"project_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+func NewEvent(eventType string, projectID uuid.UUID, data interface{}) Event {
+	return Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		ProjectID: projectID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+type Subscription struct {
+	ID         uuid.UUID
+	URL        string
+	Secret     string
+	EventTypes []string
+}
+
+// Wants reports whether the subscription receives events of the given type.
+// A subscription without event types receives everything.
+func (s Subscription) Wants(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateURL checks that a subscription URL is an absolute http(s) URL.
+func ValidateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// Sign returns the value of the signature header for body: "sha256=" followed
+// by the hex HMAC-SHA256 of the body keyed with the subscription secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign. Receivers can use it as is.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Deliverer posts signed payloads. Retrying is left to the caller.
+type Deliverer struct {
+	Client *http.Client
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// IsPermanent reports whether a Send error will not go away on retry.
+func IsPermanent(err error) bool {
+	_, ok := err.(permanentError)
+	return ok
+}
+
+// Send makes a single delivery attempt. 4xx responses other than 408 and
+// 429 are permanent errors since repeating the same request will not change
+// the answer.
+func (d Deliverer) Send(ctx context.Context, sub Subscription, eventType, deliveryID string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, eventType)
+	req.Header.Set(HeaderDelivery, deliveryID)
+	if sub.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(sub.Secret, body))
+	}
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s responded with %s", sub.URL, resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// Payload marshals an event into the body sent to subscribers.
+func Payload(e Event) ([]byte, error) {
+	return json.Marshal(e)
+}
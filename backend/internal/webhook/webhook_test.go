@@ -0,0 +1,129 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T, status int) (*httptest.Server, <-chan received) {
+	t.Helper()
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got
+}
+
+func TestSendSignsPayload(t *testing.T) {
+	srv, got := newReceiver(t, http.StatusNoContent)
+	sub := Subscription{ID: uuid.New(), URL: srv.URL, Secret: "s3cret"}
+
+	projectID := uuid.New()
+	event := NewEvent(EventRunFinished, projectID, map[string]interface{}{"run_id": "r1", "passed": 3})
+	body, err := Payload(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (Deliverer{}).Send(context.Background(), sub, event.Type, event.ID.String(), body); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	r := <-got
+	if r.header.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q", r.header.Get("Content-Type"))
+	}
+	if r.header.Get(HeaderEvent) != EventRunFinished {
+		t.Errorf("%s = %q", HeaderEvent, r.header.Get(HeaderEvent))
+	}
+	if r.header.Get(HeaderDelivery) != event.ID.String() {
+		t.Errorf("%s = %q", HeaderDelivery, r.header.Get(HeaderDelivery))
+	}
+	sig := r.header.Get(HeaderSignature)
+	if !Verify("s3cret", r.body, sig) {
+		t.Errorf("signature %q does not verify", sig)
+	}
+	if Verify("other", r.body, sig) {
+		t.Error("signature verifies with the wrong secret")
+	}
+
+	var payload struct {
+		ID        uuid.UUID              `json:"id"`
+		Type      string                 `json:"type"`
+		ProjectID uuid.UUID              `json:"project_id"`
+		CreatedAt time.Time              `json:"created_at"`
+		Data      map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(r.body, &payload); err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+	if payload.ID != event.ID || payload.Type != EventRunFinished || payload.ProjectID != projectID || payload.CreatedAt.IsZero() {
+		t.Errorf("payload = %+v", payload)
+	}
+	if payload.Data["run_id"] != "r1" || payload.Data["passed"] != float64(3) {
+		t.Errorf("payload data = %v", payload.Data)
+	}
+}
+
+func TestSendWithoutSecretIsUnsigned(t *testing.T) {
+	srv, got := newReceiver(t, http.StatusOK)
+	err := (Deliverer{}).Send(context.Background(), Subscription{URL: srv.URL}, EventRunFinished, "d1", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig := (<-got).header.Get(HeaderSignature); sig != "" {
+		t.Errorf("unexpected signature %q", sig)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusGone, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		srv, _ := newReceiver(t, tt.status)
+		err := (Deliverer{}).Send(context.Background(), Subscription{URL: srv.URL}, EventRunFinished, "d1", []byte(`{}`))
+		if err == nil {
+			t.Errorf("status %d: expected an error", tt.status)
+			continue
+		}
+		if IsPermanent(err) != tt.permanent {
+			t.Errorf("status %d: permanent = %v, want %v", tt.status, IsPermanent(err), tt.permanent)
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, u := range []string{"http://example.com/hook", "https://example.com:8443/a?b=c"} {
+		if err := ValidateURL(u); err != nil {
+			t.Errorf("ValidateURL(%q) = %v", u, err)
+		}
+	}
+	for _, u := range []string{"", "example.com/hook", "/hook", "ftp://example.com", "file:///etc/passwd", "http://", "http//x"} {
+		if err := ValidateURL(u); err == nil {
+			t.Errorf("ValidateURL(%q) accepted", u)
+		}
+	}
+}
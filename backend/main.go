@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,6 +14,8 @@ import (
 	"time"
 
 	"zis/internal/config"
+	"zis/internal/executor"
+	"zis/internal/webhook"
 
 	"github.com/google/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -19,9 +23,14 @@ import (
 )
 
 type Project struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
+	ID             uuid.UUID `json:"id"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	Status         string    `json:"status"`
+	Responsible    string    `json:"responsible"`
+	CompletionDate *string   `json:"completion_date,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 type Entity struct {
@@ -33,41 +42,85 @@ type Entity struct {
 }
 
 type TestCase struct {
-	ID            uuid.UUID       `json:"id"`
-	Name          string          `json:"name"`
-	Description   string          `json:"description"`
-	JSONData      json.RawMessage `json:"json_data"`
-	EntityID      uuid.UUID       `json:"entity_id"`
-	ProjectID     uuid.UUID       `json:"project_id"`
-	RequirementID string          `json:"requirement_id"`
+	ID             uuid.UUID       `json:"id"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	JSONData       json.RawMessage `json:"json_data"`
+	EntityID       uuid.UUID       `json:"entity_id"`
+	ProjectID      uuid.UUID       `json:"project_id"`
+	RequirementIDs []uuid.UUID     `json:"requirement_ids,omitempty"`
+	DependsOn      []uuid.UUID     `json:"depends_on,omitempty"`
+	Steps          []TestStep      `json:"steps,omitempty"`
+	Version        int             `json:"version,omitempty"`
+	LastStatus     string          `json:"last_status,omitempty"`
+	CreatedAt      *time.Time      `json:"created_at,omitempty"`
+	UpdatedAt      *time.Time      `json:"updated_at,omitempty"`
 }
 
 type TestCaseRunRequest struct {
-	TestCaseIDs []uuid.UUID `json:"test_case_ids"`
+	TestCaseIDs   []uuid.UUID `json:"test_case_ids"`
+	StartedBy     string      `json:"started_by"`
+	EnvironmentID *uuid.UUID  `json:"environment_id"`
+	SuiteID       *uuid.UUID  `json:"suite_id"`
+	// Mode is "automated" (the default) or "manual". Manual runs assign
+	// every case to a tester, from Assignments or else Assignee.
+	Mode        string               `json:"mode"`
+	Assignee    string               `json:"assignee"`
+	Assignments map[uuid.UUID]string `json:"assignments"`
 }
 
 type TestCaseRunResult struct {
-	TestCaseID uuid.UUID `json:"test_case_id"`
-	Status     string    `json:"status"`
-	RunTime    time.Time `json:"run_time"`
+	ID         uuid.UUID          `json:"id"`
+	RunID      uuid.UUID          `json:"run_id"`
+	TestCaseID uuid.UUID          `json:"test_case_id"`
+	Status     string             `json:"status"`
+	Message    string             `json:"message,omitempty"`
+	Output     json.RawMessage    `json:"output,omitempty"`
+	DurationMs int64              `json:"duration_ms"`
+	Attempts   int                `json:"attempts"`
+	Flaky      bool               `json:"flaky"`
+	Version    int                `json:"test_case_version,omitempty"`
+	AttemptLog []TestRunAttempt   `json:"attempt_log,omitempty"`
+	Iterations []TestRunIteration `json:"iterations,omitempty"`
+	RunTime    time.Time          `json:"run_time"`
 }
 
 type Requirement struct {
-	ID   uuid.UUID `json:"id"`
-	Name string    `json:"name"`
+	ID            uuid.UUID  `json:"id"`
+	Key           string     `json:"key"`
+	Title         string     `json:"title"`
+	Description   string     `json:"description"`
+	Status        string     `json:"status"`
+	ProjectID     uuid.UUID  `json:"project_id"`
+	EntityID      *uuid.UUID `json:"entity_id,omitempty"`
+	ExternalID    string     `json:"external_id,omitempty"`
+	SyncedAt      *time.Time `json:"synced_at,omitempty"`
+	Verification  string     `json:"verification_status,omitempty"`
+	VerifiedRunID *uuid.UUID `json:"verified_run_id,omitempty"`
+	VerifiedAt    *time.Time `json:"verified_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// validationError is reported to the client as 400 Bad Request.
+type validationError string
+
+func (e validationError) Error() string {
+	return string(e)
 }
 
 var (
-	db     *sql.DB
-	router *httprouter.Router
+	db                    *sql.DB
+	router                *httprouter.Router
+	executors             *executor.Registry
+	runWorkers            *runWorkerPool
+	requirementsWriteback *writebackDispatcher
 )
 
 func initDB() {
 	cfg := config.GetConfig()
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		cfg.Database.Host, cfg.Database.Port, cfg.Database.User, cfg.Database.Password, cfg.Database.DBname)
 	var err error
-	db, err = sql.Open("postgres", connStr)
+	db, err = sql.Open("postgres", cfg.Database.DSN())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -79,6 +132,24 @@ func initDB() {
 	log.Println("Database connected successfully")
 }
 
+func initExecutors() {
+	cfg := config.GetConfig()
+
+	executors = executor.NewRegistry()
+	executors.Register("noop", executor.Noop{})
+	executors.Register("assert", executor.Assert{})
+	executors.Register("http", executor.HTTP{Client: &http.Client{}})
+	executors.Register("sql", executor.NewSQL(cfg.Datasources))
+	if cfg.Shell.Enabled {
+		executors.Register("shell", executor.Shell{
+			AllowedCommands: cfg.Shell.AllowedCommands,
+			WorkDir:         cfg.Shell.WorkDir,
+			MaxOutputBytes:  cfg.Shell.MaxOutputBytes,
+			Timeout:         cfg.Shell.Timeout,
+		})
+	}
+}
+
 func getStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -95,9 +166,16 @@ func createProject(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 	if project.ID == uuid.Nil {
 		project.ID = uuid.New()
 	}
+	if err := validateProject(&project); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	query := `INSERT INTO projects (id, name, description) VALUES ($1, $2, $3)`
-	_, err := db.Exec(query, project.ID, project.Name, project.Description)
+	query := `INSERT INTO projects (id, name, description, status, responsible, completion_date)
+		VALUES ($1, $2, $3, $4, $5, $6::date)
+		RETURNING ` + projectColumns
+	project, err := scanProject(db.QueryRow(query, project.ID, project.Name, project.Description,
+		project.Status, project.Responsible, project.CompletionDate))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -143,6 +221,16 @@ func batchUploadTestCases(w http.ResponseWriter, r *http.Request, _ httprouter.P
 		return
 	}
 
+	for i := range testCases {
+		if testCases[i].ID == uuid.Nil {
+			testCases[i].ID = uuid.New()
+		}
+		if err := executor.ValidatePolicy(testCases[i].JSONData); err != nil {
+			http.Error(w, fmt.Sprintf("test case %s: %s", testCases[i].ID, err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -150,9 +238,23 @@ func batchUploadTestCases(w http.ResponseWriter, r *http.Request, _ httprouter.P
 	}
 	defer tx.Rollback()
 
+	err = validateDependencies(tx, testCases)
+	if err == nil {
+		err = validateRequirementLinks(tx, testCases)
+	}
+	if err != nil {
+		var verr validationError
+		if errors.As(err, &verr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	stmt, err := tx.Prepare(`
-		INSERT INTO test_cases (id, name, description, json_data, entity_id, project_id, requirement_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO test_cases (id, name, description, json_data, entity_id, project_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
 	`)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -161,10 +263,31 @@ func batchUploadTestCases(w http.ResponseWriter, r *http.Request, _ httprouter.P
 	defer stmt.Close()
 
 	for _, tc := range testCases {
-		if tc.ID == uuid.Nil {
-			tc.ID = uuid.New()
+		_, err := stmt.Exec(tc.ID, tc.Name, tc.Description, tc.JSONData, tc.EntityID, tc.ProjectID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Dependencies go in after all cases so that a batch may reference its own
+	// members in any order.
+	for _, tc := range testCases {
+		err := saveDependencies(tx, tc)
+		if err == nil {
+			err = saveRequirementLinks(tx, tc)
+		}
+		if err == nil && len(tc.Steps) > 0 {
+			err = saveSteps(tx, tc)
+		}
+		if err == nil {
+			err = snapshotTestCase(tx, tc.ID)
+		}
+		var verr validationError
+		if errors.As(err, &verr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		_, err := stmt.Exec(tc.ID, tc.Name, tc.Description, tc.JSONData, tc.EntityID, tc.ProjectID, tc.RequirementID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -176,6 +299,10 @@ func batchUploadTestCases(w http.ResponseWriter, r *http.Request, _ httprouter.P
 		return
 	}
 
+	for i := range testCases {
+		testCases[i].Version = 1
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(testCases)
 }
@@ -187,84 +314,171 @@ func runTestCases(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
+	if req.SuiteID != nil {
+		if len(req.TestCaseIDs) > 0 {
+			http.Error(w, "Provide either test_case_ids or suite_id", http.StatusBadRequest)
+			return
+		}
+		suite, err := loadSuite(*req.SuiteID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Suite not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if req.TestCaseIDs, err = resolveSuite(suite); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(req.TestCaseIDs) == 0 {
+			http.Error(w, "Suite has no test cases", http.StatusBadRequest)
+			return
+		}
+	}
+
 	if len(req.TestCaseIDs) == 0 {
 		http.Error(w, "No test case IDs provided", http.StatusBadRequest)
 		return
 	}
 
-	placeholders := make([]interface{}, len(req.TestCaseIDs))
-	for i := range req.TestCaseIDs {
-		placeholders[i] = req.TestCaseIDs[i]
-	}
-
-	query := `SELECT id, requirement_id FROM test_cases WHERE id = ANY($1)`
-	rows, err := db.Query(query, pq.Array(req.TestCaseIDs))
+	var found int
+	err := db.QueryRow(`SELECT COUNT(*) FROM test_cases WHERE id = ANY($1) AND deleted_at IS NULL`,
+		pq.Array(uniqueIDs(req.TestCaseIDs))).Scan(&found)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
+	if found != len(uniqueIDs(req.TestCaseIDs)) {
+		http.Error(w, "Unknown or deleted test case", http.StatusBadRequest)
+		return
+	}
 
-	var results []TestCaseRunResult
-	for rows.Next() {
-		var requirementID, tcID uuid.UUID
-		if err := rows.Scan(&tcID, &requirementID); err != nil {
-			continue
+	// An environment carries its project's secrets, so it may only be used
+	// for cases of that project.
+	if req.EnvironmentID != nil {
+		var envProjectID uuid.UUID
+		err := db.QueryRow("SELECT project_id FROM environments WHERE id = $1 AND deleted_at IS NULL", *req.EnvironmentID).Scan(&envProjectID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Environment not found", http.StatusNotFound)
+			return
 		}
-
-		status := "passed"
-		if time.Now().Unix()%2 == 0 {
-			status = "failed"
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		result := TestCaseRunResult{
-			TestCaseID: tcID,
-			Status:     status,
-			RunTime:    time.Now(),
+		var foreign bool
+		err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM test_cases WHERE id = ANY($1) AND project_id <> $2)`,
+			pq.Array(req.TestCaseIDs), envProjectID).Scan(&foreign)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if foreign {
+			http.Error(w, "All test cases must belong to the environment's project", http.StatusBadRequest)
+			return
 		}
-		results = append(results, result)
+	}
 
-		sendNotification(requirementID, tcID, status)
+	run := TestRun{
+		ID:            uuid.New(),
+		StartedBy:     req.StartedBy,
+		Mode:          runModeAutomated,
+		Status:        runStatusQueued,
+		TestCaseIDs:   req.TestCaseIDs,
+		EnvironmentID: req.EnvironmentID,
+		SuiteID:       req.SuiteID,
+	}
+	switch req.Mode {
+	case "", runModeAutomated:
+		if err := enqueueRun(&run); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		runWorkers.notify()
+	case runModeManual:
+		err := startManualRun(&run, req.Assignments, req.Assignee)
+		var verr validationError
+		if errors.As(err, &verr) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown run mode %q", req.Mode), http.StatusBadRequest)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	w.Header().Set("Location", "/runs/"+run.ID.String())
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
 }
 
 func getRequirements(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// TODO: integration
-
-	projectID := ps.ByName("projectId")
-	entityID := ps.ByName("entityId")
-
-	_ = projectID
-	_ = entityID
+	// Requirements owned by an external provider are synced into the local
+	// store, so this only ever reads from Postgres.
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+	entityID, err := uuid.Parse(ps.ByName("entityId"))
+	if err != nil {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
 
-	requirements := []Requirement{
-		{ID: uuid.New(), Name: "Requirement 1"},
-		{ID: uuid.New(), Name: "Requirement 2"},
-		{ID: uuid.New(), Name: "Requirement 3"},
+	requirements, err := queryRequirements(`
+		SELECT `+requirementColumns+` FROM requirements
+		WHERE project_id = $1 AND entity_id = $2
+		ORDER BY key
+	`, projectID, entityID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(requirements)
 }
 
-func sendNotification(requirementID, testCaseID uuid.UUID, status string) {
-	// TODO: integration
-
-	log.Printf("Proizvolnyi push 2: requirement=%s, testcase=%s, status=%s\n",
-		requirementID, testCaseID, status)
+// sendNotification queues case.status_changed when a case ends a run in a
+// different state than it was left in by its previous run.
+func sendNotification(tx *sql.Tx, run *TestRun, tc TestCase, result TestCaseRunResult) error {
+	var previous string
+	err := tx.QueryRow(`
+		SELECT status FROM test_run_results
+		WHERE test_case_id = $1 AND run_id <> $2
+		ORDER BY run_time DESC LIMIT 1
+	`, tc.ID, run.ID).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if previous == result.Status {
+		return nil
+	}
 
-	// client := &http.Client{}
-	// req, _ := http.NewRequest("POST", "https://external-system.com/notify", nil)
-	// ...
+	return enqueueEvent(tx, webhook.NewEvent(webhook.EventCaseStatusChanged, tc.ProjectID, caseStatusChangedData{
+		RunID:          run.ID,
+		TestCaseID:     tc.ID,
+		TestCaseName:   tc.Name,
+		RequirementIDs: tc.RequirementIDs,
+		PreviousStatus: previous,
+		Status:         result.Status,
+		Message:        result.Message,
+	}))
 }
 
 func corsMiddleware(next httprouter.Handle) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		if r.Method == "OPTIONS" {
@@ -281,10 +495,89 @@ func setupRouter() *httprouter.Router {
 
 	router.GET("/status", corsMiddleware(getStatus))
 	router.POST("/projects", corsMiddleware(createProject))
+	router.GET("/projects", corsMiddleware(listProjects))
+	router.GET("/projects/:projectId", corsMiddleware(getProject))
+	router.PUT("/projects/:projectId", corsMiddleware(updateProject))
+	router.PATCH("/projects/:projectId", corsMiddleware(patchProject))
+	router.DELETE("/projects/:projectId", corsMiddleware(deleteProject))
+	router.POST("/projects/:projectId/archive", corsMiddleware(archiveProject))
+	router.POST("/projects/:projectId/unarchive", corsMiddleware(unarchiveProject))
 	router.POST("/entities", corsMiddleware(addEntity))
+	router.GET("/projects/:projectId/entities", corsMiddleware(listEntities))
+	router.GET("/entities/:id", corsMiddleware(getEntity))
+	router.PUT("/entities/:id", corsMiddleware(updateEntity))
+	router.DELETE("/entities/:id", corsMiddleware(deleteEntity))
 	router.POST("/testcases/batch", corsMiddleware(batchUploadTestCases))
 	router.POST("/testcases/run", corsMiddleware(runTestCases))
+	// httprouter cannot register POST /testcases/:id/... next to the static
+	// POST routes above, so restore takes the case and version in its body.
+	router.POST("/testcases/restore", corsMiddleware(restoreTestCase))
+	router.GET("/testcases", corsMiddleware(listTestCases))
+	router.POST("/projects/:projectId/suites", corsMiddleware(createSuite))
+	router.GET("/projects/:projectId/suites", corsMiddleware(listSuites))
+	router.GET("/suites/:id", corsMiddleware(getSuite))
+	router.PUT("/suites/:id", corsMiddleware(updateSuite))
+	router.DELETE("/suites/:id", corsMiddleware(deleteSuite))
+	router.GET("/suites/:id/testcases", corsMiddleware(listSuiteTestCases))
+	router.POST("/projects/:projectId/plans", corsMiddleware(createPlan))
+	router.GET("/projects/:projectId/plans", corsMiddleware(listPlans))
+	router.GET("/plans/:id", corsMiddleware(getPlan))
+	router.PUT("/plans/:id", corsMiddleware(updatePlan))
+	router.DELETE("/plans/:id", corsMiddleware(deletePlan))
+	router.GET("/plans/:id/progress", corsMiddleware(getPlanProgress))
+	router.GET("/testcases/:id", corsMiddleware(getTestCase))
+	router.PUT("/testcases/:id", corsMiddleware(updateTestCase))
+	router.PATCH("/testcases/:id", corsMiddleware(patchTestCase))
+	router.DELETE("/testcases/:id", corsMiddleware(deleteTestCase))
+	router.GET("/testcases/:id/steps", corsMiddleware(listTestCaseSteps))
+	router.PUT("/testcases/:id/steps", corsMiddleware(replaceTestCaseSteps))
+	router.PATCH("/testcases/:id/steps", corsMiddleware(reorderTestCaseSteps))
+	router.PUT("/testcases/:id/steps/:stepId", corsMiddleware(putTestCaseStep))
+	router.DELETE("/testcases/:id/steps/:stepId", corsMiddleware(deleteTestCaseStep))
+	router.GET("/testcases/:id/versions", corsMiddleware(listTestCaseVersions))
+	router.GET("/testcases/:id/versions/:version", corsMiddleware(getTestCaseVersion))
+	router.GET("/testcases/:id/diff", corsMiddleware(diffTestCase))
+	router.POST("/projects/:projectId/shared-steps", corsMiddleware(createSharedStep))
+	router.GET("/projects/:projectId/shared-steps", corsMiddleware(listSharedSteps))
+	router.GET("/shared-steps/:id", corsMiddleware(getSharedStep))
+	router.PUT("/shared-steps/:id", corsMiddleware(updateSharedStep))
+	router.DELETE("/shared-steps/:id", corsMiddleware(deleteSharedStep))
 	router.GET("/projects/:projectId/entities/:entityId/requirements", corsMiddleware(getRequirements))
+	router.GET("/projects/:projectId/requirements", corsMiddleware(listProjectRequirements))
+	router.GET("/projects/:projectId/traceability", corsMiddleware(getTraceability))
+	router.POST("/projects/:projectId/requirements", corsMiddleware(createRequirement))
+	router.POST("/projects/:projectId/requirements/sync", corsMiddleware(syncRequirements))
+	router.GET("/requirements/:id", corsMiddleware(getRequirement))
+	router.PUT("/requirements/:id", corsMiddleware(updateRequirement))
+	router.DELETE("/requirements/:id", corsMiddleware(deleteRequirement))
+	router.GET("/requirements/:id/testcases", corsMiddleware(listRequirementTestCases))
+	router.POST("/requirements/:id/testcases", corsMiddleware(linkRequirementTestCases))
+	router.DELETE("/requirements/:id/testcases/:testCaseId", corsMiddleware(unlinkRequirementTestCase))
+	router.GET("/runs", corsMiddleware(listRuns))
+	router.GET("/runs/:runId", corsMiddleware(getRun))
+	router.GET("/runs/:runId/status", corsMiddleware(getRunStatus))
+	router.POST("/runs/:runId/cancel", corsMiddleware(cancelRun))
+	router.GET("/runs/:runId/verdicts", corsMiddleware(listRunVerdicts))
+	router.POST("/runs/:runId/writeback", corsMiddleware(writebackRunHandler))
+	router.GET("/runs/:runId/cases", corsMiddleware(listRunAssignments))
+	router.PUT("/runs/:runId/cases/:testCaseId/tester", corsMiddleware(reassignTestCase))
+	router.PUT("/runs/:runId/cases/:testCaseId/steps/:step", corsMiddleware(submitStepResult))
+	router.POST("/runs/:runId/cases/:testCaseId/verdict", corsMiddleware(submitVerdict))
+	router.GET("/assignments", corsMiddleware(listAssignments))
+	router.GET("/runs/:runId/events", corsMiddleware(streamRunEvents))
+	router.GET("/testcases/:id/history", corsMiddleware(getTestCaseHistory))
+	router.POST("/projects/:projectId/environments", corsMiddleware(createEnvironment))
+	router.GET("/projects/:projectId/environments", corsMiddleware(listEnvironments))
+	router.GET("/environments/:id", corsMiddleware(getEnvironment))
+	router.PUT("/environments/:id", corsMiddleware(updateEnvironment))
+	router.DELETE("/environments/:id", corsMiddleware(deleteEnvironment))
+	router.POST("/projects/:projectId/webhooks", corsMiddleware(createWebhook))
+	router.GET("/projects/:projectId/webhooks", corsMiddleware(listWebhooks))
+	router.DELETE("/webhooks/:id", corsMiddleware(deleteWebhook))
+	router.GET("/admin/dead-letters", corsMiddleware(listDeadLetters))
+	router.POST("/admin/dead-letters/:id/replay", corsMiddleware(replayDeadLetter))
+	router.PUT("/entities/:id/datasets/:name", corsMiddleware(putEntityDataset))
+	router.GET("/entities/:id/datasets/:name", corsMiddleware(getEntityDataset))
 
 	return router
 }
@@ -318,7 +611,22 @@ func waitForShutdown() {
 func main() {
 	initDB()
 	defer db.Close()
+	initExecutors()
+	initRequirementsProvider()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runWorkers = startRunWorkers(ctx)
+	dispatcher := startOutboxDispatcher(ctx)
+	requirementsSync := startRequirementsSync(ctx)
+	requirementsWriteback = startRequirementsWriteback(ctx)
+	listenRunEvents(ctx)
 
 	go startServer()
 	waitForShutdown()
+
+	cancel()
+	runWorkers.wait()
+	dispatcher.Wait()
+	requirementsSync.Wait()
+	requirementsWriteback.Wait()
 }
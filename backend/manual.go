@@ -0,0 +1,564 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"zis/internal/executor"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+const assignmentStatusPending = "pending"
+
+// ManualStep is one step of a manual case together with what the tester
+// observed.
+type ManualStep struct {
+	Step         int        `json:"step"`
+	Action       string     `json:"action"`
+	TestData     string     `json:"test_data,omitempty"`
+	Expected     string     `json:"expected,omitempty"`
+	ActualResult string     `json:"actual_result,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Comment      string     `json:"comment,omitempty"`
+	UpdatedAt    *time.Time `json:"updated_at,omitempty"`
+}
+
+// ManualAssignment is a case of a manual run handed to a tester. It stays
+// pending until the tester submits a verdict.
+type ManualAssignment struct {
+	RunID          uuid.UUID    `json:"run_id"`
+	TestCaseID     uuid.UUID    `json:"test_case_id"`
+	TestCaseName   string       `json:"test_case_name"`
+	Version        int          `json:"test_case_version,omitempty"`
+	Tester         string       `json:"tester"`
+	Status         string       `json:"status"`
+	Comment        string       `json:"comment,omitempty"`
+	ExpectedResult string       `json:"expected_result,omitempty"`
+	Steps          []ManualStep `json:"steps"`
+	AssignedAt     time.Time    `json:"assigned_at"`
+	ResolvedAt     *time.Time   `json:"resolved_at,omitempty"`
+}
+
+type manualVerdict struct {
+	Status  string `json:"status"`
+	Comment string `json:"comment"`
+	Tester  string `json:"tester"`
+}
+
+func validManualStatus(status string) bool {
+	switch status {
+	case executor.StatusPassed, executor.StatusFailed, executor.StatusSkipped, resultStatusBlocked:
+		return true
+	}
+	return false
+}
+
+// manualSteps returns the steps a tester follows. Structured steps, with
+// shared steps already expanded, take precedence; otherwise they are read
+// from json_data, where "steps" is a list of strings or of {action,
+// expected} objects. "expected_result" in json_data describes the overall
+// outcome.
+func manualSteps(tc TestCase) ([]ManualStep, string) {
+	var spec struct {
+		Steps          []json.RawMessage `json:"steps"`
+		ExpectedResult string            `json:"expected_result"`
+	}
+	if len(tc.JSONData) > 0 {
+		json.Unmarshal(tc.JSONData, &spec)
+	}
+
+	if len(tc.Steps) > 0 {
+		steps := make([]ManualStep, len(tc.Steps))
+		for i, s := range tc.Steps {
+			steps[i] = ManualStep{Step: i + 1, Action: s.Action, TestData: s.TestData, Expected: s.ExpectedResult}
+		}
+		return steps, spec.ExpectedResult
+	}
+
+	steps := make([]ManualStep, 0, len(spec.Steps))
+	for i, raw := range spec.Steps {
+		step := ManualStep{Step: i + 1}
+		if err := json.Unmarshal(raw, &step.Action); err != nil {
+			var s struct {
+				Action   string `json:"action"`
+				Expected string `json:"expected"`
+			}
+			json.Unmarshal(raw, &s)
+			step.Action, step.Expected = s.Action, s.Expected
+		}
+		steps = append(steps, step)
+	}
+	return steps, spec.ExpectedResult
+}
+
+// startManualRun opens a run that no worker picks up: each case is
+// assigned to a tester and the run finishes once every case has a verdict.
+// Each assignment pins the case's current version, so later edits do not
+// change what the tester is asked to do. Dependencies between cases are left
+// to the testers.
+func startManualRun(run *TestRun, assignments map[uuid.UUID]string, assignee string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// A case is assigned once, so listing it twice must not inflate the total.
+	run.TestCaseIDs = uniqueIDs(run.TestCaseIDs)
+
+	var found int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM test_cases WHERE id = ANY($1) AND deleted_at IS NULL`, pq.Array(run.TestCaseIDs)).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(run.TestCaseIDs) {
+		return validationError("Unknown test case in manual run")
+	}
+
+	run.Mode = runModeManual
+	run.Status = runStatusRunning
+	run.Total = len(run.TestCaseIDs)
+	err = tx.QueryRow(`
+		INSERT INTO test_runs (id, started_by, mode, status, test_case_ids, environment_id, suite_id, total, started_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, CURRENT_TIMESTAMP)
+		RETURNING created_at, started_at
+	`, run.ID, run.StartedBy, run.Mode, run.Status, pq.Array(run.TestCaseIDs), run.EnvironmentID, run.SuiteID,
+		run.Total).Scan(&run.CreatedAt, &run.StartedAt)
+	if err != nil {
+		return err
+	}
+
+	for _, tcID := range run.TestCaseIDs {
+		tester := assignments[tcID]
+		if tester == "" {
+			tester = assignee
+		}
+		if tester == "" {
+			return validationError(fmt.Sprintf("test case %s has no tester", tcID))
+		}
+		_, err := tx.Exec(`
+			INSERT INTO manual_assignments (run_id, test_case_id, tester, status, test_case_version)
+			SELECT $1, id, $3, $4, version FROM test_cases WHERE id = $2
+		`, run.ID, tcID, tester, assignmentStatusPending)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func queryAssignments(query string, args ...interface{}) ([]ManualAssignment, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []ManualAssignment{}
+	var cases []TestCase
+	// Cases without a snapshot of the pinned version fall back to their
+	// current steps.
+	var unversioned []uuid.UUID
+	var pinned []bool
+	for rows.Next() {
+		var a ManualAssignment
+		var jsonData, versionSteps []byte
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&a.RunID, &a.TestCaseID, &a.TestCaseName, &a.Version, &jsonData, &versionSteps,
+			&a.Tester, &a.Status, &a.Comment, &a.AssignedAt, &resolvedAt); err != nil {
+			return nil, err
+		}
+		if resolvedAt.Valid {
+			a.ResolvedAt = &resolvedAt.Time
+		}
+		tc := TestCase{ID: a.TestCaseID, JSONData: jsonData}
+		if versionSteps == nil {
+			unversioned = append(unversioned, a.TestCaseID)
+		} else if err := json.Unmarshal(versionSteps, &tc.Steps); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+		cases = append(cases, tc)
+		pinned = append(pinned, versionSteps != nil)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	steps, err := loadTestCaseSteps(unversioned)
+	if err != nil {
+		return nil, err
+	}
+	for i := range assignments {
+		if !pinned[i] {
+			cases[i].Steps = steps[cases[i].ID]
+		}
+		assignments[i].Steps, assignments[i].ExpectedResult = manualSteps(cases[i])
+	}
+	return assignments, loadStepResults(assignments)
+}
+
+// assignmentColumns read the case as of the version pinned by the
+// assignment; v.steps is NULL when that version has no snapshot.
+const assignmentColumns = `a.run_id, a.test_case_id, COALESCE(v.name, tc.name), COALESCE(a.test_case_version, 0),
+	CASE WHEN v.test_case_id IS NULL THEN tc.json_data ELSE v.json_data END, v.steps,
+	a.tester, a.status, COALESCE(a.comment, ''), a.assigned_at, a.resolved_at`
+
+const assignmentFrom = `manual_assignments a
+	JOIN test_cases tc ON tc.id = a.test_case_id
+	LEFT JOIN test_case_versions v ON v.test_case_id = a.test_case_id AND v.version = a.test_case_version`
+
+// loadStepResults merges the recorded step results into the steps.
+func loadStepResults(assignments []ManualAssignment) error {
+	if len(assignments) == 0 {
+		return nil
+	}
+	type key struct{ run, tc uuid.UUID }
+	index := make(map[key]int, len(assignments))
+	runIDs := make([]uuid.UUID, 0, len(assignments))
+	for i, a := range assignments {
+		index[key{a.RunID, a.TestCaseID}] = i
+		runIDs = append(runIDs, a.RunID)
+	}
+
+	rows, err := db.Query(`
+		SELECT run_id, test_case_id, step, COALESCE(actual_result, ''), status, COALESCE(comment, ''), updated_at
+		FROM manual_step_results WHERE run_id = ANY($1)
+		ORDER BY step
+	`, pq.Array(uniqueIDs(runIDs)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var runID, tcID uuid.UUID
+		var r ManualStep
+		var updatedAt time.Time
+		if err := rows.Scan(&runID, &tcID, &r.Step, &r.ActualResult, &r.Status, &r.Comment, &updatedAt); err != nil {
+			return err
+		}
+		i, ok := index[key{runID, tcID}]
+		if !ok {
+			continue
+		}
+		a := &assignments[i]
+		for len(a.Steps) < r.Step {
+			a.Steps = append(a.Steps, ManualStep{Step: len(a.Steps) + 1})
+		}
+		s := &a.Steps[r.Step-1]
+		s.ActualResult, s.Status, s.Comment, s.UpdatedAt = r.ActualResult, r.Status, r.Comment, &updatedAt
+	}
+	return rows.Err()
+}
+
+func loadAssignment(runID, tcID uuid.UUID) (ManualAssignment, error) {
+	assignments, err := queryAssignments(`
+		SELECT `+assignmentColumns+`
+		FROM `+assignmentFrom+`
+		WHERE a.run_id = $1 AND a.test_case_id = $2
+	`, runID, tcID)
+	if err != nil {
+		return ManualAssignment{}, err
+	}
+	if len(assignments) == 0 {
+		return ManualAssignment{}, sql.ErrNoRows
+	}
+	return assignments[0], nil
+}
+
+func writeAssignments(w http.ResponseWriter, assignments []ManualAssignment, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(assignments)
+}
+
+func listRunAssignments(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	runID, err := uuid.Parse(ps.ByName("runId"))
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	assignments, err := queryAssignments(`
+		SELECT `+assignmentColumns+`
+		FROM `+assignmentFrom+`
+		WHERE a.run_id = $1
+		ORDER BY array_position((SELECT test_case_ids FROM test_runs WHERE id = $1), a.test_case_id)
+	`, runID)
+	writeAssignments(w, assignments, err)
+}
+
+// listAssignments is a tester's work queue across open manual runs.
+func listAssignments(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	q := r.URL.Query()
+	tester := q.Get("tester")
+	if tester == "" {
+		http.Error(w, "tester is required", http.StatusBadRequest)
+		return
+	}
+	status := q.Get("status")
+	if status == "" {
+		status = assignmentStatusPending
+	}
+
+	assignments, err := queryAssignments(`
+		SELECT `+assignmentColumns+`
+		FROM `+assignmentFrom+`
+		JOIN test_runs run ON run.id = a.run_id
+		WHERE a.tester = $1 AND a.status = $2 AND run.status = $3
+		ORDER BY a.assigned_at
+	`, tester, status, runStatusRunning)
+	writeAssignments(w, assignments, err)
+}
+
+// requireOpenAssignment loads an assignment that still accepts input,
+// writing the error response itself otherwise.
+func requireOpenAssignment(w http.ResponseWriter, ps httprouter.Params) (*TestRun, ManualAssignment, bool) {
+	runID, err := uuid.Parse(ps.ByName("runId"))
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return nil, ManualAssignment{}, false
+	}
+	tcID, err := uuid.Parse(ps.ByName("testCaseId"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return nil, ManualAssignment{}, false
+	}
+
+	run, err := scanRun(db.QueryRow(`SELECT `+runColumns+` FROM test_runs WHERE id = $1`, runID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return nil, ManualAssignment{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, ManualAssignment{}, false
+	}
+	if run.Mode != runModeManual || run.Status != runStatusRunning {
+		http.Error(w, "Run is not an open manual run", http.StatusConflict)
+		return nil, ManualAssignment{}, false
+	}
+
+	a, err := loadAssignment(runID, tcID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Test case is not part of the run", http.StatusNotFound)
+		return nil, ManualAssignment{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, ManualAssignment{}, false
+	}
+	if a.Status != assignmentStatusPending {
+		http.Error(w, "Test case already has a verdict", http.StatusConflict)
+		return nil, ManualAssignment{}, false
+	}
+	return &run, a, true
+}
+
+func reassignTestCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var body struct {
+		Tester string `json:"tester"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Tester == "" {
+		http.Error(w, "tester is required", http.StatusBadRequest)
+		return
+	}
+
+	_, a, ok := requireOpenAssignment(w, ps)
+	if !ok {
+		return
+	}
+
+	_, err := db.Exec(`
+		UPDATE manual_assignments SET tester = $3, assigned_at = CURRENT_TIMESTAMP
+		WHERE run_id = $1 AND test_case_id = $2 AND status = $4
+	`, a.RunID, a.TestCaseID, body.Tester, assignmentStatusPending)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a, err = loadAssignment(a.RunID, a.TestCaseID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+func submitStepResult(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var step ManualStep
+	if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validManualStatus(step.Status) {
+		http.Error(w, "status must be passed, failed, blocked or skipped", http.StatusBadRequest)
+		return
+	}
+
+	_, a, ok := requireOpenAssignment(w, ps)
+	if !ok {
+		return
+	}
+
+	n, err := strconv.Atoi(ps.ByName("step"))
+	if err != nil || n < 1 || n > len(a.Steps) {
+		http.Error(w, "Unknown step", http.StatusNotFound)
+		return
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO manual_step_results (run_id, test_case_id, step, actual_result, status, comment)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (run_id, test_case_id, step) DO UPDATE SET
+			actual_result = EXCLUDED.actual_result, status = EXCLUDED.status, comment = EXCLUDED.comment,
+			updated_at = CURRENT_TIMESTAMP
+	`, a.RunID, a.TestCaseID, n, step.ActualResult, step.Status, step.Comment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a, err = loadAssignment(a.RunID, a.TestCaseID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+// submitVerdict resolves a case of a manual run. The verdict is recorded
+// like any other run result, and the last verdict finishes the run.
+func submitVerdict(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var verdict manualVerdict
+	if err := json.NewDecoder(r.Body).Decode(&verdict); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validManualStatus(verdict.Status) {
+		http.Error(w, "status must be passed, failed, blocked or skipped", http.StatusBadRequest)
+		return
+	}
+
+	run, a, ok := requireOpenAssignment(w, ps)
+	if !ok {
+		return
+	}
+	if verdict.Tester != "" && verdict.Tester != a.Tester {
+		http.Error(w, "Test case is assigned to another tester", http.StatusForbidden)
+		return
+	}
+
+	// Claiming the assignment first keeps two submissions from both
+	// recording a result.
+	var resolvedAt time.Time
+	err := db.QueryRow(`
+		UPDATE manual_assignments SET status = $3, comment = NULLIF($4, ''), resolved_at = CURRENT_TIMESTAMP
+		WHERE run_id = $1 AND test_case_id = $2 AND status = $5
+		RETURNING resolved_at
+	`, a.RunID, a.TestCaseID, verdict.Status, verdict.Comment, assignmentStatusPending).Scan(&resolvedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Test case already has a verdict", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cases, err := loadRunCases([]uuid.UUID{a.TestCaseID})
+	if err == nil && len(cases) == 0 {
+		err = sql.ErrNoRows
+	}
+	if err == nil {
+		// The result belongs to the version the tester actually followed.
+		if a.Version > 0 {
+			cases[0].Version = a.Version
+		}
+		err = recordRunResult(run, cases[0], manualResult(a, verdict, resolvedAt))
+	}
+	if err != nil {
+		_, undoErr := db.Exec(`
+			UPDATE manual_assignments SET status = $3, comment = NULL, resolved_at = NULL
+			WHERE run_id = $1 AND test_case_id = $2
+		`, a.RunID, a.TestCaseID, assignmentStatusPending)
+		if undoErr != nil {
+			log.Printf("Failed to reopen %s in run %s: %v", a.TestCaseID, a.RunID, undoErr)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := finishManualRun(run.ID); err != nil {
+		log.Printf("Failed to finish manual run %s: %v", run.ID, err)
+	}
+
+	a, err = loadAssignment(a.RunID, a.TestCaseID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
+
+func manualResult(a ManualAssignment, verdict manualVerdict, resolvedAt time.Time) TestCaseRunResult {
+	output, _ := json.Marshal(map[string]interface{}{
+		"tester": a.Tester,
+		"steps":  a.Steps,
+	})
+	return TestCaseRunResult{
+		TestCaseID: a.TestCaseID,
+		Status:     verdict.Status,
+		Message:    verdict.Comment,
+		Output:     output,
+		DurationMs: resolvedAt.Sub(a.AssignedAt).Milliseconds(),
+		RunTime:    resolvedAt,
+	}
+}
+
+// finishManualRun closes the run once no case is pending. Only the caller
+// whose update flips the status goes on to finish it.
+func finishManualRun(runID uuid.UUID) error {
+	run, err := scanRun(db.QueryRow(`
+		UPDATE test_runs SET status = $2
+		WHERE id = $1 AND status = $3
+			AND NOT EXISTS (SELECT 1 FROM manual_assignments WHERE run_id = $1 AND status = $4)
+		RETURNING `+runColumns, runID, runStatusFinished, runStatusRunning, assignmentStatusPending))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cases, err := loadRunCases(run.TestCaseIDs)
+	if err != nil {
+		return err
+	}
+	if err := finishRun(&run, runStatusFinished, "", runProjects(cases)); err != nil {
+		return err
+	}
+	requirementsWriteback.queue(run.ID)
+	return nil
+}
@@ -2,7 +2,13 @@ CREATE TABLE projects (
     id UUID PRIMARY KEY,
     name VARCHAR(255) NOT NULL,
     description TEXT,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    status VARCHAR(32) NOT NULL DEFAULT 'active',
+    previous_status VARCHAR(32),
+    responsible VARCHAR(255),
+    completion_date DATE,
+    requirement_seq BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
 CREATE TABLE entities (
@@ -11,7 +17,8 @@ CREATE TABLE entities (
     description TEXT,
     project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
     json_data JSONB,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
 );
 
 CREATE TABLE test_cases (
@@ -21,14 +28,294 @@ CREATE TABLE test_cases (
     json_data JSONB,
     entity_id UUID NOT NULL REFERENCES entities(id) ON DELETE CASCADE,
     project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
-    requirement_id VARCHAR(255),
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE TABLE requirements (
+    id UUID PRIMARY KEY,
+    key VARCHAR(64) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    description TEXT,
+    status VARCHAR(32) NOT NULL DEFAULT 'draft',
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    entity_id UUID REFERENCES entities(id) ON DELETE SET NULL,
+    external_id VARCHAR(255),
+    synced_at TIMESTAMP,
+    verification_status VARCHAR(32),
+    verified_run_id UUID,
+    verified_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT requirements_project_key_unique UNIQUE (project_id, key),
+    CONSTRAINT requirements_project_external_id_unique UNIQUE (project_id, external_id)
+);
+
+CREATE TABLE test_case_requirements (
+    test_case_id UUID NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+    requirement_id UUID NOT NULL REFERENCES requirements(id) ON DELETE CASCADE,
+    PRIMARY KEY (test_case_id, requirement_id)
+);
+
+CREATE TABLE entity_datasets (
+    entity_id UUID NOT NULL REFERENCES entities(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (entity_id, name)
+);
+
+CREATE TABLE test_case_dependencies (
+    test_case_id UUID NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+    depends_on_id UUID NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+    PRIMARY KEY (test_case_id, depends_on_id),
+    CHECK (test_case_id <> depends_on_id)
+);
+
+CREATE TABLE shared_steps (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    action TEXT NOT NULL,
+    test_data TEXT,
+    expected_result TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE test_case_steps (
+    id UUID PRIMARY KEY,
+    test_case_id UUID NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    action TEXT,
+    test_data TEXT,
+    expected_result TEXT,
+    shared_step_id UUID REFERENCES shared_steps(id),
+    UNIQUE (test_case_id, position) DEFERRABLE INITIALLY DEFERRED,
+    CHECK (action IS NOT NULL OR shared_step_id IS NOT NULL)
+);
+
+CREATE TABLE test_case_versions (
+    test_case_id UUID NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    json_data JSONB,
+    entity_id UUID NOT NULL,
+    depends_on UUID[] NOT NULL DEFAULT '{}',
+    requirement_ids UUID[] NOT NULL DEFAULT '{}',
+    steps JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (test_case_id, version)
+);
+
+CREATE INDEX idx_projects_status ON projects(status);
 CREATE INDEX idx_entities_project_id ON entities(project_id);
 CREATE INDEX idx_test_cases_entity_id ON test_cases(entity_id);
-CREATE INDEX idx_test_cases_project_id ON test_cases(project_id);
-CREATE INDEX idx_test_cases_requirement_id ON test_cases(requirement_id);
+CREATE INDEX idx_test_case_steps_shared_step_id ON test_case_steps(shared_step_id);
+CREATE INDEX idx_shared_steps_project_id ON shared_steps(project_id);
+CREATE INDEX idx_test_cases_project_id ON test_cases(project_id, created_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_requirements_entity_id ON requirements(entity_id);
+CREATE INDEX idx_test_case_requirements_requirement_id ON test_case_requirements(requirement_id);
+CREATE INDEX idx_test_case_dependencies_depends_on_id ON test_case_dependencies(depends_on_id);
 
 CREATE INDEX idx_entities_json_data ON entities USING GIN (json_data);
-CREATE INDEX idx_test_cases_json_data ON test_cases USING GIN (json_data);
\ No newline at end of file
+CREATE INDEX idx_test_cases_json_data ON test_cases USING GIN (json_data);
+
+CREATE TABLE environments (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    variables JSONB NOT NULL DEFAULT '{}',
+    secrets JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX environments_project_name_unique ON environments(project_id, name) WHERE deleted_at IS NULL;
+
+CREATE TABLE test_suites (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    kind VARCHAR(16) NOT NULL DEFAULT 'static',
+    filter JSONB,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE test_suite_cases (
+    suite_id UUID NOT NULL REFERENCES test_suites(id) ON DELETE CASCADE,
+    test_case_id UUID NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    PRIMARY KEY (suite_id, test_case_id)
+);
+
+CREATE TABLE test_plans (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    goal TEXT,
+    deadline DATE,
+    testers TEXT[] NOT NULL DEFAULT '{}',
+    metrics TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE test_plan_suites (
+    plan_id UUID NOT NULL REFERENCES test_plans(id) ON DELETE CASCADE,
+    suite_id UUID NOT NULL REFERENCES test_suites(id) ON DELETE CASCADE,
+    PRIMARY KEY (plan_id, suite_id)
+);
+
+CREATE TABLE test_plan_requirements (
+    plan_id UUID NOT NULL REFERENCES test_plans(id) ON DELETE CASCADE,
+    requirement_id UUID NOT NULL REFERENCES requirements(id) ON DELETE CASCADE,
+    PRIMARY KEY (plan_id, requirement_id)
+);
+
+CREATE TABLE test_runs (
+    id UUID PRIMARY KEY,
+    started_by VARCHAR(255),
+    mode VARCHAR(16) NOT NULL DEFAULT 'automated',
+    status VARCHAR(32) NOT NULL,
+    message TEXT,
+    test_case_ids UUID[] NOT NULL,
+    environment_id UUID REFERENCES environments(id) ON DELETE SET NULL,
+    suite_id UUID REFERENCES test_suites(id) ON DELETE SET NULL,
+    total INTEGER NOT NULL DEFAULT 0,
+    passed INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    errors INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    blocked INTEGER NOT NULL DEFAULT 0,
+    cancel_requested BOOLEAN NOT NULL DEFAULT FALSE,
+    claimed_until TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+CREATE TABLE test_run_results (
+    id UUID PRIMARY KEY,
+    run_id UUID NOT NULL REFERENCES test_runs(id) ON DELETE CASCADE,
+    test_case_id UUID NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+    status VARCHAR(32) NOT NULL,
+    message TEXT,
+    output JSONB,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    test_case_version INTEGER,
+    run_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE test_run_attempts (
+    id UUID PRIMARY KEY,
+    result_id UUID NOT NULL REFERENCES test_run_results(id) ON DELETE CASCADE,
+    iteration INTEGER NOT NULL DEFAULT 0,
+    attempt INTEGER NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    message TEXT,
+    output JSONB,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (result_id, iteration, attempt)
+);
+
+CREATE TABLE test_run_iterations (
+    id UUID PRIMARY KEY,
+    result_id UUID NOT NULL REFERENCES test_run_results(id) ON DELETE CASCADE,
+    iteration INTEGER NOT NULL,
+    parameters JSONB,
+    status VARCHAR(32) NOT NULL,
+    message TEXT,
+    output JSONB,
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 1,
+    UNIQUE (result_id, iteration)
+);
+
+CREATE INDEX idx_test_runs_created_at ON test_runs(created_at);
+CREATE INDEX idx_test_runs_environment_id ON test_runs(environment_id);
+CREATE INDEX idx_test_runs_suite_id ON test_runs(suite_id);
+CREATE INDEX idx_test_suites_project_id ON test_suites(project_id);
+CREATE INDEX idx_test_suite_cases_test_case_id ON test_suite_cases(test_case_id);
+CREATE INDEX idx_test_plans_project_id ON test_plans(project_id);
+CREATE INDEX idx_test_runs_queued ON test_runs(created_at) WHERE status = 'queued';
+CREATE INDEX idx_test_run_results_run_id ON test_run_results(run_id);
+CREATE INDEX idx_test_run_results_test_case_id ON test_run_results(test_case_id, run_time);
+
+
+CREATE TABLE manual_assignments (
+    run_id UUID NOT NULL REFERENCES test_runs(id) ON DELETE CASCADE,
+    test_case_id UUID NOT NULL REFERENCES test_cases(id) ON DELETE CASCADE,
+    tester VARCHAR(255) NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',
+    test_case_version INTEGER,
+    comment TEXT,
+    assigned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP,
+    PRIMARY KEY (run_id, test_case_id)
+);
+
+CREATE TABLE manual_step_results (
+    run_id UUID NOT NULL,
+    test_case_id UUID NOT NULL,
+    step INTEGER NOT NULL,
+    actual_result TEXT,
+    status VARCHAR(32) NOT NULL,
+    comment TEXT,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (run_id, test_case_id, step),
+    FOREIGN KEY (run_id, test_case_id) REFERENCES manual_assignments(run_id, test_case_id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_manual_assignments_tester ON manual_assignments(tester, status);
+
+CREATE TABLE requirement_verdicts (
+    run_id UUID NOT NULL REFERENCES test_runs(id) ON DELETE CASCADE,
+    requirement_id UUID NOT NULL REFERENCES requirements(id) ON DELETE CASCADE,
+    verdict VARCHAR(32) NOT NULL,
+    total INTEGER NOT NULL,
+    passed INTEGER NOT NULL,
+    failed INTEGER NOT NULL,
+    claimed_until TIMESTAMP,
+    reported_at TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (run_id, requirement_id)
+);
+
+CREATE TABLE webhook_subscriptions (
+    id UUID PRIMARY KEY,
+    project_id UUID NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    secret TEXT,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_subscriptions_project_id ON webhook_subscriptions(project_id);
+
+CREATE TABLE outbox (
+    id UUID PRIMARY KEY,
+    event_id UUID NOT NULL,
+    event_type VARCHAR(64) NOT NULL,
+    subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    payload JSONB NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX idx_outbox_pending ON outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_outbox_dead ON outbox(created_at) WHERE status = 'dead';
\ No newline at end of file
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"zis/internal/config"
+	"zis/internal/webhook"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	outboxStatusPending   = "pending"
+	outboxStatusDelivered = "delivered"
+	outboxStatusDead      = "dead"
+
+	// outboxMaxAttempts caps webhooks.max_attempts so that a message is not
+	// retried for days.
+	outboxMaxAttempts = 20
+	outboxMaxBackoff  = time.Hour
+)
+
+type OutboxMessage struct {
+	ID             uuid.UUID       `json:"id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	SubscriptionID uuid.UUID       `json:"subscription_id"`
+	URL            string          `json:"url"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+type outboxDispatcher struct {
+	deliverer   webhook.Deliverer
+	maxAttempts int
+	backoff     time.Duration
+	lease       time.Duration
+	batchSize   int
+}
+
+// startOutboxDispatcher drains the outbox until ctx is cancelled. Messages
+// are leased rather than locked for the duration of the HTTP call, so a
+// crashed replica only delays delivery until the lease runs out. Receivers
+// may therefore see a message more than once and should deduplicate on the
+// delivery header.
+func startOutboxDispatcher(ctx context.Context) *sync.WaitGroup {
+	cfg := config.GetConfig()
+	d := &outboxDispatcher{
+		deliverer: webhook.Deliverer{
+			Client: &http.Client{Timeout: cfg.Webhooks.Timeout},
+		},
+		maxAttempts: cfg.Webhooks.MaxAttempts,
+		backoff:     cfg.Webhooks.Backoff,
+		lease:       2 * cfg.Webhooks.Timeout,
+		batchSize:   cfg.Webhooks.BatchSize,
+	}
+	if d.maxAttempts < 1 {
+		d.maxAttempts = 1
+	}
+	if d.maxAttempts > outboxMaxAttempts {
+		d.maxAttempts = outboxMaxAttempts
+	}
+	if d.batchSize < 1 {
+		d.batchSize = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(cfg.Webhooks.DispatchInterval)
+		defer ticker.Stop()
+
+		for {
+			for ctx.Err() == nil {
+				n, err := d.dispatchBatch(ctx)
+				if err != nil {
+					log.Printf("Outbox dispatch failed: %v", err)
+					break
+				}
+				if n < d.batchSize {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return &wg
+}
+
+func (d *outboxDispatcher) dispatchBatch(ctx context.Context) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE outbox o SET attempts = o.attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + $2::double precision * INTERVAL '1 millisecond'
+		FROM webhook_subscriptions s
+		WHERE o.subscription_id = s.id AND o.id IN (
+			SELECT id FROM outbox
+			WHERE status = $3 AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT $1
+		)
+		RETURNING o.id, o.event_type, o.payload, o.attempts, s.id, s.url, COALESCE(s.secret, '')
+	`, d.batchSize, d.lease.Milliseconds(), outboxStatusPending)
+	if err != nil {
+		return 0, err
+	}
+
+	type leased struct {
+		id        uuid.UUID
+		eventType string
+		payload   []byte
+		attempts  int
+		sub       webhook.Subscription
+	}
+	var batch []leased
+	for rows.Next() {
+		var m leased
+		if err := rows.Scan(&m.id, &m.eventType, &m.payload, &m.attempts, &m.sub.ID, &m.sub.URL, &m.sub.Secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Messages are sent concurrently so that the whole batch finishes within
+	// one client timeout, well inside the lease.
+	var wg sync.WaitGroup
+	for _, m := range batch {
+		wg.Add(1)
+		go func(m leased) {
+			defer wg.Done()
+			d.deliver(ctx, m.id, m.eventType, m.payload, m.attempts, m.sub)
+		}(m)
+	}
+	wg.Wait()
+	return len(batch), nil
+}
+
+func (d *outboxDispatcher) deliver(ctx context.Context, id uuid.UUID, eventType string, payload []byte, attempts int, sub webhook.Subscription) {
+	err := d.deliverer.Send(ctx, sub, eventType, id.String(), payload)
+	if err == nil {
+		_, err = db.Exec(`UPDATE outbox SET status = $2, delivered_at = CURRENT_TIMESTAMP, last_error = NULL WHERE id = $1`,
+			id, outboxStatusDelivered)
+		if err != nil {
+			log.Printf("Failed to mark outbox message %s delivered: %v", id, err)
+		}
+		return
+	}
+
+	status := outboxStatusPending
+	if webhook.IsPermanent(err) || attempts >= d.maxAttempts {
+		status = outboxStatusDead
+		log.Printf("Outbox message %s is dead after %d attempts: %v", id, attempts, err)
+	}
+	retryIn := d.retryDelay(attempts)
+	_, dbErr := db.Exec(`
+		UPDATE outbox SET status = $2, last_error = $3,
+			next_attempt_at = CURRENT_TIMESTAMP + $4::double precision * INTERVAL '1 millisecond'
+		WHERE id = $1
+	`, id, status, err.Error(), retryIn.Milliseconds())
+	if dbErr != nil {
+		log.Printf("Failed to update outbox message %s: %v", id, dbErr)
+	}
+}
+
+// retryDelay doubles the backoff after every failed attempt, up to
+// outboxMaxBackoff.
+func (d *outboxDispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > outboxMaxBackoff {
+		delay = outboxMaxBackoff
+	}
+	return delay
+}
+
+func listDeadLetters(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.Query(`
+		SELECT o.id, o.event_id, o.event_type, o.subscription_id, s.url, o.payload, o.status,
+			o.attempts, COALESCE(o.last_error, ''), o.created_at
+		FROM outbox o JOIN webhook_subscriptions s ON s.id = o.subscription_id
+		WHERE o.status = $1
+		ORDER BY o.created_at DESC LIMIT $2
+	`, outboxStatusDead, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	messages := []OutboxMessage{}
+	for rows.Next() {
+		var m OutboxMessage
+		var payload []byte
+		err := rows.Scan(&m.ID, &m.EventID, &m.EventType, &m.SubscriptionID, &m.URL, &payload,
+			&m.Status, &m.Attempts, &m.LastError, &m.CreatedAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		m.Payload = payload
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+func replayDeadLetter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec(`
+		UPDATE outbox SET status = $2, attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $3
+	`, id, outboxStatusPending, outboxStatusDead)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists bool
+		err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM outbox WHERE id = $1)`, id).Scan(&exists)
+		if err != nil || !exists {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Message is not dead-lettered", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// parameterSource is the "parameters" field of a test case json_data. It is
+// either an array of rows or an object pointing at inline CSV or at a
+// dataset attached to the test case entity.
+type parameterSource struct {
+	Rows    []map[string]interface{} `json:"rows"`
+	CSV     string                   `json:"csv"`
+	Dataset string                   `json:"dataset"`
+}
+
+// loadParameterRows returns the parameter table of a test case, or nil if
+// the case is not parameterized.
+func loadParameterRows(tc TestCase) ([]map[string]interface{}, error) {
+	var data struct {
+		Parameters json.RawMessage `json:"parameters"`
+	}
+	if len(tc.JSONData) == 0 || json.Unmarshal(tc.JSONData, &data) != nil {
+		return nil, nil
+	}
+	raw := bytes.TrimSpace(data.Parameters)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	if raw[0] == '[' {
+		var rows []map[string]interface{}
+		if err := json.Unmarshal(raw, &rows); err != nil {
+			return nil, fmt.Errorf("invalid parameter rows: %w", err)
+		}
+		return rows, nil
+	}
+
+	var src parameterSource
+	if err := json.Unmarshal(raw, &src); err != nil {
+		return nil, fmt.Errorf("invalid parameters: %w", err)
+	}
+	switch {
+	case src.Rows != nil:
+		return src.Rows, nil
+	case src.CSV != "":
+		return parseCSVRows(src.CSV)
+	case src.Dataset != "":
+		var content string
+		err := db.QueryRow(`SELECT content FROM entity_datasets WHERE entity_id = $1 AND name = $2`,
+			tc.EntityID, src.Dataset).Scan(&content)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("dataset %q not found on entity %s", src.Dataset, tc.EntityID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return parseCSVRows(content)
+	}
+	return nil, fmt.Errorf("parameters need rows, csv or dataset")
+}
+
+// parseCSVRows reads a CSV table whose first line holds the parameter names.
+func parseCSVRows(text string) ([]map[string]interface{}, error) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("csv has no header")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid csv: %w", err)
+	}
+
+	rows := []map[string]interface{}{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid csv: %w", err)
+		}
+
+		row := make(map[string]interface{}, len(header))
+		for i, name := range header {
+			row[name] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func putEntityDataset(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	entityID, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+	name := ps.ByName("name")
+
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rows, err := parseCSVRows(string(content))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM entities WHERE id = $1 AND deleted_at IS NULL)", entityID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Entity not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO entity_datasets (entity_id, name, content) VALUES ($1, $2, $3)
+		ON CONFLICT (entity_id, name) DO UPDATE SET content = EXCLUDED.content, updated_at = CURRENT_TIMESTAMP
+	`, entityID, name, string(content))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"entity_id": entityID, "name": name, "rows": len(rows)})
+}
+
+func getEntityDataset(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	entityID, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+		return
+	}
+
+	var content string
+	err = db.QueryRow(`SELECT content FROM entity_datasets WHERE entity_id = $1 AND name = $2`,
+		entityID, ps.ByName("name")).Scan(&content)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Dataset not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Write([]byte(content))
+}
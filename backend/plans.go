@@ -0,0 +1,478 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"zis/internal/executor"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+type TestPlan struct {
+	ID             uuid.UUID   `json:"id"`
+	ProjectID      uuid.UUID   `json:"project_id"`
+	Name           string      `json:"name"`
+	Goal           string      `json:"goal"`
+	Deadline       *string     `json:"deadline,omitempty"`
+	Testers        []string    `json:"testers"`
+	Metrics        string      `json:"metrics"`
+	SuiteIDs       []uuid.UUID `json:"suite_ids"`
+	RequirementIDs []uuid.UUID `json:"requirement_ids"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+}
+
+type TestPlanProgress struct {
+	PlanID     uuid.UUID `json:"plan_id"`
+	Total      int       `json:"total"`
+	Executed   int       `json:"executed"`
+	Passed     int       `json:"passed"`
+	Failed     int       `json:"failed"`
+	Blocked    int       `json:"blocked"`
+	Skipped    int       `json:"skipped"`
+	NotRun     int       `json:"not_run"`
+	PassRate   float64   `json:"pass_rate"`
+	Completion float64   `json:"completion"`
+	Deadline   *string   `json:"deadline,omitempty"`
+	Overdue    bool      `json:"overdue"`
+}
+
+const planColumns = `id, project_id, name, COALESCE(goal, ''), to_char(deadline, 'YYYY-MM-DD'), testers,
+	COALESCE(metrics, ''), created_at, updated_at`
+
+func scanPlan(row interface{ Scan(...interface{}) error }) (TestPlan, error) {
+	var p TestPlan
+	var deadline sql.NullString
+	err := row.Scan(&p.ID, &p.ProjectID, &p.Name, &p.Goal, &deadline, pq.Array(&p.Testers),
+		&p.Metrics, &p.CreatedAt, &p.UpdatedAt)
+	if deadline.Valid {
+		p.Deadline = &deadline.String
+	}
+	if p.Testers == nil {
+		p.Testers = []string{}
+	}
+	p.SuiteIDs = []uuid.UUID{}
+	p.RequirementIDs = []uuid.UUID{}
+	return p, err
+}
+
+// loadPlanLinks fills in the suites and requirements of the given plans.
+func loadPlanLinks(plans []TestPlan) error {
+	if len(plans) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(plans))
+	index := make(map[uuid.UUID]int, len(plans))
+	for i, p := range plans {
+		ids[i] = p.ID
+		index[p.ID] = i
+	}
+
+	rows, err := db.Query(`
+		SELECT plan_id, suite_id, NULL::uuid FROM test_plan_suites WHERE plan_id = ANY($1)
+		UNION ALL
+		SELECT plan_id, NULL::uuid, requirement_id FROM test_plan_requirements WHERE plan_id = ANY($1)
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var planID uuid.UUID
+		var suiteID, reqID uuid.NullUUID
+		if err := rows.Scan(&planID, &suiteID, &reqID); err != nil {
+			return err
+		}
+		i, ok := index[planID]
+		if !ok {
+			continue
+		}
+		if suiteID.Valid {
+			plans[i].SuiteIDs = append(plans[i].SuiteIDs, suiteID.UUID)
+		}
+		if reqID.Valid {
+			plans[i].RequirementIDs = append(plans[i].RequirementIDs, reqID.UUID)
+		}
+	}
+	return rows.Err()
+}
+
+func loadPlan(id uuid.UUID) (TestPlan, error) {
+	plan, err := scanPlan(db.QueryRow(`SELECT `+planColumns+` FROM test_plans WHERE id = $1`, id))
+	if err != nil {
+		return plan, err
+	}
+	plans := []TestPlan{plan}
+	err = loadPlanLinks(plans)
+	return plans[0], err
+}
+
+func validatePlan(tx *sql.Tx, plan *TestPlan) error {
+	if plan.Name == "" {
+		return validationError("Plan name is required")
+	}
+	if err := normalizeDate("deadline", &plan.Deadline); err != nil {
+		return err
+	}
+	if plan.Testers == nil {
+		plan.Testers = []string{}
+	}
+	plan.SuiteIDs = uniqueIDs(plan.SuiteIDs)
+	plan.RequirementIDs = uniqueIDs(plan.RequirementIDs)
+
+	var suites, reqs int
+	err := tx.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM test_suites WHERE id = ANY($1) AND project_id = $3),
+			(SELECT COUNT(*) FROM requirements WHERE id = ANY($2) AND project_id = $3)
+	`, pq.Array(plan.SuiteIDs), pq.Array(plan.RequirementIDs), plan.ProjectID).Scan(&suites, &reqs)
+	if err != nil {
+		return err
+	}
+	if suites != len(plan.SuiteIDs) {
+		return validationError("Plan suites must exist in the plan's project")
+	}
+	if reqs != len(plan.RequirementIDs) {
+		return validationError("Plan requirements must exist in the plan's project")
+	}
+	return nil
+}
+
+func savePlan(plan TestPlan, create bool) (TestPlan, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return plan, err
+	}
+	defer tx.Rollback()
+
+	if err := validatePlan(tx, &plan); err != nil {
+		return plan, err
+	}
+
+	var saved TestPlan
+	if create {
+		saved, err = scanPlan(tx.QueryRow(`
+			INSERT INTO test_plans (id, project_id, name, goal, deadline, testers, metrics)
+			VALUES ($1, $2, $3, $4, $5::date, $6, $7)
+			RETURNING `+planColumns,
+			plan.ID, plan.ProjectID, plan.Name, plan.Goal, plan.Deadline, pq.Array(plan.Testers), plan.Metrics))
+	} else {
+		saved, err = scanPlan(tx.QueryRow(`
+			UPDATE test_plans SET name = $2, goal = $3, deadline = $4::date, testers = $5, metrics = $6,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING `+planColumns,
+			plan.ID, plan.Name, plan.Goal, plan.Deadline, pq.Array(plan.Testers), plan.Metrics))
+	}
+	if err != nil {
+		return plan, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM test_plan_suites WHERE plan_id = $1`, plan.ID)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM test_plan_requirements WHERE plan_id = $1`, plan.ID)
+	}
+	if err == nil && len(plan.SuiteIDs) > 0 {
+		_, err = tx.Exec(`INSERT INTO test_plan_suites (plan_id, suite_id) SELECT $1, unnest($2::uuid[])`,
+			plan.ID, pq.Array(plan.SuiteIDs))
+	}
+	if err == nil && len(plan.RequirementIDs) > 0 {
+		_, err = tx.Exec(`INSERT INTO test_plan_requirements (plan_id, requirement_id) SELECT $1, unnest($2::uuid[])`,
+			plan.ID, pq.Array(plan.RequirementIDs))
+	}
+	if err != nil {
+		return plan, err
+	}
+
+	if plan.SuiteIDs != nil {
+		saved.SuiteIDs = plan.SuiteIDs
+	}
+	if plan.RequirementIDs != nil {
+		saved.RequirementIDs = plan.RequirementIDs
+	}
+	return saved, tx.Commit()
+}
+
+func writeSavedPlan(w http.ResponseWriter, plan TestPlan, create bool) {
+	saved, err := savePlan(plan, create)
+	var verr validationError
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	case errors.As(err, &verr):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if create {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(saved)
+}
+
+func createPlan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var plan TestPlan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if plan.ID == uuid.Nil {
+		plan.ID = uuid.New()
+	}
+	plan.ProjectID = projectID
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1)", projectID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	writeSavedPlan(w, plan, true)
+}
+
+func listPlans(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT `+planColumns+` FROM test_plans WHERE project_id = $1 ORDER BY deadline NULLS LAST, name`, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	plans := []TestPlan{}
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plans = append(plans, plan)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := loadPlanLinks(plans); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plans)
+}
+
+func getPlan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid plan ID", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := loadPlan(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+func updatePlan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid plan ID", http.StatusBadRequest)
+		return
+	}
+
+	var plan TestPlan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var projectID uuid.UUID
+	err = db.QueryRow(`SELECT project_id FROM test_plans WHERE id = $1`, id).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plan.ID = id
+	plan.ProjectID = projectID
+	writeSavedPlan(w, plan, false)
+}
+
+func deletePlan(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid plan ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM test_plans WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// planTestCases returns every case in scope of the plan: the members of its
+// suites and the cases linked to its requirements.
+func planTestCases(plan TestPlan) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	for _, suiteID := range plan.SuiteIDs {
+		suite, err := loadSuite(suiteID)
+		if err != nil {
+			return nil, err
+		}
+		suiteCases, err := resolveSuite(suite)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, suiteCases...)
+	}
+
+	if len(plan.RequirementIDs) > 0 {
+		rows, err := db.Query(`SELECT test_case_id FROM test_case_requirements WHERE requirement_id = ANY($1)`,
+			pq.Array(plan.RequirementIDs))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return uniqueIDs(ids), nil
+}
+
+// getPlanProgress counts the latest result of every case in the plan's
+// scope. Only results recorded since the plan was created count, so a new
+// plan starts from zero rather than inheriting old runs.
+func getPlanProgress(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid plan ID", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := loadPlan(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Plan not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ids, err := planTestCases(plan)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT lr.status, COUNT(*)
+		FROM unnest($1::uuid[]) AS c(id)
+		LEFT JOIN LATERAL (
+			SELECT status FROM test_run_results
+			WHERE test_case_id = c.id AND run_time >= (SELECT created_at FROM test_plans WHERE id = $2)
+			ORDER BY run_time DESC LIMIT 1
+		) lr ON true
+		GROUP BY lr.status
+	`, pq.Array(ids), plan.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	progress := TestPlanProgress{PlanID: plan.ID, Total: len(ids), Deadline: plan.Deadline}
+	for rows.Next() {
+		var status sql.NullString
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		switch status.String {
+		case executor.StatusPassed:
+			progress.Passed += n
+		case executor.StatusFailed, executor.StatusError:
+			progress.Failed += n
+		case resultStatusBlocked:
+			progress.Blocked += n
+		case executor.StatusSkipped:
+			// Cases of a cancelled run are recorded as skipped too.
+			progress.Skipped += n
+		default:
+			progress.NotRun += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	progress.Executed = progress.Passed + progress.Failed
+	if progress.Executed > 0 {
+		progress.PassRate = float64(progress.Passed) / float64(progress.Executed)
+	}
+	if progress.Total > 0 {
+		progress.Completion = float64(progress.Executed) / float64(progress.Total)
+	}
+	if plan.Deadline != nil {
+		deadline, _ := time.Parse("2006-01-02", *plan.Deadline)
+		progress.Overdue = progress.NotRun+progress.Blocked+progress.Skipped > 0 && time.Now().After(deadline.AddDate(0, 0, 1))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	projectStatusActive    = "active"
+	projectStatusPending   = "pending"
+	projectStatusCompleted = "completed"
+	projectStatusArchived  = "archived"
+)
+
+const projectColumns = `id, name, COALESCE(description, ''), status, COALESCE(responsible, ''),
+	to_char(completion_date, 'YYYY-MM-DD'), created_at, updated_at`
+
+// projectPatch holds the fields of a PATCH body; absent fields stay as
+// they are and an empty completion_date clears it.
+type projectPatch struct {
+	Name           *string `json:"name"`
+	Description    *string `json:"description"`
+	Status         *string `json:"status"`
+	Responsible    *string `json:"responsible"`
+	CompletionDate *string `json:"completion_date"`
+}
+
+func scanProject(row interface{ Scan(...interface{}) error }) (Project, error) {
+	var p Project
+	var completionDate sql.NullString
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Status, &p.Responsible, &completionDate,
+		&p.CreatedAt, &p.UpdatedAt)
+	if completionDate.Valid {
+		p.CompletionDate = &completionDate.String
+	}
+	return p, err
+}
+
+func validateProject(p *Project) error {
+	if p.Name == "" {
+		return validationError("Project name is required")
+	}
+	switch p.Status {
+	case "":
+		p.Status = projectStatusActive
+	case projectStatusActive, projectStatusPending, projectStatusCompleted, projectStatusArchived:
+	default:
+		return validationError(fmt.Sprintf("Unknown project status %q", p.Status))
+	}
+	return normalizeDate("completion_date", &p.CompletionDate)
+}
+
+// normalizeDate checks an optional YYYY-MM-DD date, turning "" into nil.
+func normalizeDate(field string, date **string) error {
+	if *date != nil && **date == "" {
+		*date = nil
+	}
+	if *date == nil {
+		return nil
+	}
+	if _, err := time.Parse("2006-01-02", **date); err != nil {
+		return validationError(field + " must be a YYYY-MM-DD date")
+	}
+	return nil
+}
+
+func loadProject(id uuid.UUID) (Project, error) {
+	return scanProject(db.QueryRow(`SELECT `+projectColumns+` FROM projects WHERE id = $1`, id))
+}
+
+// saveProject writes every editable field. Moving a project into the
+// archive through an update remembers its status for unarchive.
+func saveProject(p Project) (Project, error) {
+	return scanProject(db.QueryRow(`
+		UPDATE projects SET name = $2, description = $3,
+			previous_status = CASE WHEN $4::text = $7::text AND status <> $7::text THEN status ELSE previous_status END,
+			status = $4, responsible = $5, completion_date = $6::date, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+projectColumns,
+		p.ID, p.Name, p.Description, p.Status, p.Responsible, p.CompletionDate, projectStatusArchived))
+}
+
+func listProjects(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var status *string
+	if v := r.URL.Query().Get("status"); v != "" {
+		status = &v
+	}
+
+	rows, err := db.Query(`
+		SELECT `+projectColumns+` FROM projects
+		WHERE $1::text IS NULL OR status = $1
+		ORDER BY created_at DESC
+	`, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	projects := []Project{}
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		projects = append(projects, p)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projects)
+}
+
+func getProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	project, err := loadProject(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+func updateProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var project Project
+	if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	project.ID = id
+	if err := validateProject(&project); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeSavedProject(w, project)
+}
+
+func patchProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var patch projectPatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	project, err := loadProject(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if patch.Name != nil {
+		project.Name = *patch.Name
+	}
+	if patch.Description != nil {
+		project.Description = *patch.Description
+	}
+	if patch.Status != nil {
+		project.Status = *patch.Status
+	}
+	if patch.Responsible != nil {
+		project.Responsible = *patch.Responsible
+	}
+	if patch.CompletionDate != nil {
+		project.CompletionDate = patch.CompletionDate
+	}
+	if err := validateProject(&project); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeSavedProject(w, project)
+}
+
+func writeSavedProject(w http.ResponseWriter, project Project) {
+	saved, err := saveProject(project)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func deleteProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM projects WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func archiveProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	project, err := scanProject(db.QueryRow(`
+		UPDATE projects SET
+			previous_status = CASE WHEN status <> $2 THEN status ELSE previous_status END,
+			status = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+projectColumns, id, projectStatusArchived))
+	writeArchiveResult(w, project, err)
+}
+
+// unarchiveProject restores the status the project had before it was
+// archived.
+func unarchiveProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	project, err := scanProject(db.QueryRow(`
+		UPDATE projects SET status = COALESCE(previous_status, $3), previous_status = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $2
+		RETURNING `+projectColumns, id, projectStatusArchived, projectStatusActive))
+	if err == sql.ErrNoRows {
+		// Either there is no such project or it is not archived.
+		if _, loadErr := loadProject(id); loadErr == nil {
+			http.Error(w, "Project is not archived", http.StatusConflict)
+			return
+		}
+	}
+	writeArchiveResult(w, project, err)
+}
+
+func writeArchiveResult(w http.ResponseWriter, project Project, err error) {
+	if err == sql.ErrNoRows {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
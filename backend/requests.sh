@@ -15,6 +15,15 @@ curl -X POST http://localhost:8080/entities \
     }
   }'
 
+curl -X POST http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/requirements \
+  -H "Content-Type: application/json" \
+  -d '{
+	"id": "c0ffee00-1488-a0a0-baba-24ed6463dc28",
+    "title":"Users have a name and an email",
+    "status":"active",
+    "entity_id":"deadbeef-1488-a0a0-baba-24ed6463dc28"
+  }'
+
 curl -X POST http://localhost:8080/testcases/batch \
   -H "Content-Type: application/json" \
   -d '[
@@ -22,19 +31,19 @@ curl -X POST http://localhost:8080/testcases/batch \
 	"id": "17ef9c34-5f3b-436c-8bac-3e6159a3b0bc",
     "name":"User name",
     "description":"Test User Name",
-    "json_data": {"kek": "lol"},
+    "json_data": {"type": "assert", "actual": "lol", "expected": "lol"},
     "entity_id":"deadbeef-1488-a0a0-baba-24ed6463dc28",
     "project_id":"deadbeef-1488-a0a0-baba-24ed6463dc28",
-    "requirement_id":"zis_is_the_best_subject_evereverever"
+    "requirement_ids":["c0ffee00-1488-a0a0-baba-24ed6463dc28"]
   },
   {
 	"id": "48d5d033-891c-4d89-8248-0559e9cfc40e",
     "name":"User email",
     "description":"Test User email",
-    "json_data": {"dogovornyachok": "blizko"},
+    "json_data": {"type": "noop"},
     "entity_id":"deadbeef-1488-a0a0-baba-24ed6463dc28",
     "project_id":"deadbeef-1488-a0a0-baba-24ed6463dc28",
-    "requirement_id":"zis_is_the_best_subject_evereverever"
+    "requirement_ids":["c0ffee00-1488-a0a0-baba-24ed6463dc28"]
   }
 ]'
 
@@ -47,3 +56,91 @@ curl -X POST http://localhost:8080/testcases/run \
 		]}'
 
 curl http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/entities/deadbeef-1488-a0a0-baba-24ed6463dc28/requirements
+
+curl http://localhost:8080/runs
+
+curl http://localhost:8080/testcases/17ef9c34-5f3b-436c-8bac-3e6159a3b0bc/history
+
+curl http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/traceability
+
+curl "http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/traceability?format=csv"
+
+curl "http://localhost:8080/projects?status=active"
+
+curl -X PATCH http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28 \
+  -H "Content-Type: application/json" \
+  -d '{"responsible": "Ivan Petrov", "completion_date": "2024-12-15"}'
+
+curl -X POST http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/archive
+
+curl -X POST http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/unarchive
+
+curl "http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/entities?data.type=user"
+
+curl "http://localhost:8080/testcases?project_id=deadbeef-1488-a0a0-baba-24ed6463dc28&q=user&last_status=passed&limit=20"
+
+curl -X POST http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/suites \
+  -H "Content-Type: application/json" \
+  -d '{
+	"id": "5a17e000-1488-a0a0-baba-24ed6463dc28",
+    "name":"Smoke Testing Suite",
+    "test_case_ids":["17ef9c34-5f3b-436c-8bac-3e6159a3b0bc"]
+  }'
+
+curl -X POST http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/suites \
+  -H "Content-Type: application/json" \
+  -d '{"name":"Assertions", "filter": {"contains": {"type": "assert"}}}'
+
+curl -X POST http://localhost:8080/testcases/run \
+  -H "Content-Type: application/json" \
+  -d '{"suite_id":"5a17e000-1488-a0a0-baba-24ed6463dc28"}'
+
+curl -X POST http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/plans \
+  -H "Content-Type: application/json" \
+  -d '{
+	"id": "91a40000-1488-a0a0-baba-24ed6463dc28",
+    "name":"User profile plan",
+    "goal":"Verify user profile fields",
+    "deadline":"2024-12-15",
+    "testers":["Ivan Petrov","Anna Kozlova"],
+    "suite_ids":["5a17e000-1488-a0a0-baba-24ed6463dc28"],
+    "requirement_ids":["c0ffee00-1488-a0a0-baba-24ed6463dc28"]
+  }'
+
+curl http://localhost:8080/plans/91a40000-1488-a0a0-baba-24ed6463dc28/progress
+
+curl -X POST http://localhost:8080/testcases/run \
+  -H "Content-Type: application/json" \
+  -d '{"mode":"manual", "assignee":"Ivan Petrov", "suite_id":"5a17e000-1488-a0a0-baba-24ed6463dc28"}'
+
+curl "http://localhost:8080/assignments?tester=Ivan%20Petrov"
+
+
+curl -X POST http://localhost:8080/projects/deadbeef-1488-a0a0-baba-24ed6463dc28/shared-steps \
+  -H "Content-Type: application/json" \
+  -d '{
+    "id":"5bed0000-1488-a0a0-baba-24ed6463dc28",
+    "name":"Log in",
+    "action":"Log in as a regular user",
+    "test_data":"user@example.com / secret",
+    "expected_result":"The dashboard opens"
+  }'
+
+curl -X PUT http://localhost:8080/testcases/17ef9c34-5f3b-436c-8bac-3e6159a3b0bc/steps \
+  -H "Content-Type: application/json" \
+  -d '[
+    {"shared_step_id":"5bed0000-1488-a0a0-baba-24ed6463dc28"},
+    {"action":"Open the profile page", "expected_result":"The user name is shown"}
+  ]'
+
+curl -X PUT http://localhost:8080/testcases/17ef9c34-5f3b-436c-8bac-3e6159a3b0bc/steps/57e90000-1488-a0a0-baba-24ed6463dc28 \
+  -H "Content-Type: application/json" \
+  -d '{"position":2, "action":"Open the menu"}'
+
+curl http://localhost:8080/testcases/17ef9c34-5f3b-436c-8bac-3e6159a3b0bc/versions
+
+curl "http://localhost:8080/testcases/17ef9c34-5f3b-436c-8bac-3e6159a3b0bc/diff?from=1&to=2"
+
+curl -X POST http://localhost:8080/testcases/restore \
+  -H "Content-Type: application/json" \
+  -d '{"test_case_id":"17ef9c34-5f3b-436c-8bac-3e6159a3b0bc", "version":1}'
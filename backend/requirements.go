@@ -0,0 +1,527 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+const (
+	requirementStatusDraft      = "draft"
+	requirementStatusActive     = "active"
+	requirementStatusDeprecated = "deprecated"
+)
+
+const requirementColumns = `id, key, title, COALESCE(description, ''), status, project_id, entity_id,
+	COALESCE(external_id, ''), synced_at, COALESCE(verification_status, ''), verified_run_id, verified_at,
+	created_at, updated_at`
+
+func scanRequirement(row interface{ Scan(...interface{}) error }) (Requirement, error) {
+	var req Requirement
+	var entityID uuid.NullUUID
+	var syncedAt, verifiedAt sql.NullTime
+	var verifiedRunID uuid.NullUUID
+	err := row.Scan(&req.ID, &req.Key, &req.Title, &req.Description, &req.Status,
+		&req.ProjectID, &entityID, &req.ExternalID, &syncedAt, &req.Verification, &verifiedRunID, &verifiedAt,
+		&req.CreatedAt, &req.UpdatedAt)
+	if entityID.Valid {
+		req.EntityID = &entityID.UUID
+	}
+	if syncedAt.Valid {
+		req.SyncedAt = &syncedAt.Time
+	}
+	if verifiedRunID.Valid {
+		req.VerifiedRunID = &verifiedRunID.UUID
+	}
+	if verifiedAt.Valid {
+		req.VerifiedAt = &verifiedAt.Time
+	}
+	return req, err
+}
+
+func queryRequirements(query string, args ...interface{}) ([]Requirement, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reqs := []Requirement{}
+	for rows.Next() {
+		req, err := scanRequirement(rows)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, rows.Err()
+}
+
+func validateRequirement(req *Requirement) error {
+	if req.Title == "" {
+		return validationError("Requirement title is required")
+	}
+	switch req.Status {
+	case "":
+		req.Status = requirementStatusDraft
+	case requirementStatusDraft, requirementStatusActive, requirementStatusDeprecated:
+	default:
+		return validationError(fmt.Sprintf("Unknown requirement status %q", req.Status))
+	}
+	return nil
+}
+
+// checkRequirementEntity makes sure an optional entity belongs to the
+// requirement's project.
+func checkRequirementEntity(req Requirement) error {
+	if req.EntityID == nil {
+		return nil
+	}
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM entities WHERE id = $1 AND project_id = $2 AND deleted_at IS NULL)`,
+		*req.EntityID, req.ProjectID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return validationError("Entity not found in project")
+	}
+	return nil
+}
+
+func writeRequirementError(w http.ResponseWriter, err error) {
+	var verr validationError
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &verr):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.As(err, &pqErr) && pqErr.Code == "23505":
+		switch pqErr.Constraint {
+		case "requirements_project_key_unique":
+			http.Error(w, "Requirement key already exists in project", http.StatusConflict)
+		case "requirements_project_external_id_unique":
+			http.Error(w, "Requirement external ID already exists in project", http.StatusConflict)
+		default:
+			http.Error(w, "Requirement already exists", http.StatusConflict)
+		}
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func listProjectRequirements(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var status *string
+	if v := r.URL.Query().Get("status"); v != "" {
+		status = &v
+	}
+
+	reqs, err := queryRequirements(`
+		SELECT `+requirementColumns+` FROM requirements
+		WHERE project_id = $1 AND ($2::text IS NULL OR status = $2)
+		ORDER BY key
+	`, projectID, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reqs)
+}
+
+// nextRequirementKey returns the next REQ-NNN key of a project. The counter
+// lives on the project row, whose lock serializes concurrent creates, and
+// never falls behind keys given explicitly, so deleted numbers are not
+// reused.
+func nextRequirementKey(tx *sql.Tx, projectID uuid.UUID) (string, error) {
+	var n int64
+	err := tx.QueryRow(`
+		UPDATE projects SET requirement_seq = GREATEST(requirement_seq, (
+			SELECT COALESCE(MAX(substring(key FROM '^REQ-([0-9]{1,18})$')::bigint), 0)
+			FROM requirements WHERE project_id = $1
+		)) + 1
+		WHERE id = $1
+		RETURNING requirement_seq
+	`, projectID).Scan(&n)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("REQ-%03d", n), nil
+}
+
+func createRequirement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var req Requirement
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ID == uuid.Nil {
+		req.ID = uuid.New()
+	}
+	req.ProjectID = projectID
+	if err := validateRequirement(&req); err != nil {
+		writeRequirementError(w, err)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1)", projectID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+	if err := checkRequirementEntity(req); err != nil {
+		writeRequirementError(w, err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if req.Key == "" {
+		if req.Key, err = nextRequirementKey(tx, projectID); err != nil {
+			writeRequirementError(w, err)
+			return
+		}
+	}
+
+	created, err := scanRequirement(tx.QueryRow(`
+		INSERT INTO requirements (id, key, title, description, status, project_id, entity_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+requirementColumns,
+		req.ID, req.Key, req.Title, req.Description, req.Status, req.ProjectID, req.EntityID))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeRequirementError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func getRequirement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid requirement ID", http.StatusBadRequest)
+		return
+	}
+
+	req, err := scanRequirement(db.QueryRow(`SELECT `+requirementColumns+` FROM requirements WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Requirement not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(req)
+}
+
+func updateRequirement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid requirement ID", http.StatusBadRequest)
+		return
+	}
+
+	var req Requirement
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := scanRequirement(db.QueryRow(`SELECT `+requirementColumns+` FROM requirements WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Requirement not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	req.ID = id
+	req.ProjectID = current.ProjectID
+	if req.Key == "" {
+		req.Key = current.Key
+	}
+	if err := validateRequirement(&req); err != nil {
+		writeRequirementError(w, err)
+		return
+	}
+	if err := checkRequirementEntity(req); err != nil {
+		writeRequirementError(w, err)
+		return
+	}
+
+	updated, err := scanRequirement(db.QueryRow(`
+		UPDATE requirements SET key = $2, title = $3, description = $4, status = $5, entity_id = $6,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+requirementColumns,
+		req.ID, req.Key, req.Title, req.Description, req.Status, req.EntityID))
+	if err != nil {
+		writeRequirementError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func deleteRequirement(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid requirement ID", http.StatusBadRequest)
+		return
+	}
+
+	err = withLinkedVersions(func(tx *sql.Tx) ([]uuid.UUID, error) {
+		linked, err := execReturningIDs(tx, `DELETE FROM test_case_requirements WHERE requirement_id = $1 RETURNING test_case_id`, id)
+		if err != nil {
+			return nil, err
+		}
+		res, err := tx.Exec(`DELETE FROM requirements WHERE id = $1`, id)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil, sql.ErrNoRows
+		}
+		return linked, nil
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "Requirement not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withLinkedVersions runs fn, which changes requirement links and returns
+// the cases it touched, and versions those cases in the same transaction.
+func withLinkedVersions(fn func(tx *sql.Tx) ([]uuid.UUID, error)) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids, err := fn(tx)
+	if err != nil {
+		return err
+	}
+	if err := bumpLinkedVersions(tx, ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func listRequirementTestCases(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid requirement ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT tc.id, tc.name, COALESCE(tc.description, ''), tc.json_data, tc.entity_id, tc.project_id
+		FROM test_cases tc JOIN test_case_requirements l ON l.test_case_id = tc.id
+		WHERE l.requirement_id = $1
+		ORDER BY tc.name
+	`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	cases := []TestCase{}
+	for rows.Next() {
+		var tc TestCase
+		var jsonData []byte
+		if err := rows.Scan(&tc.ID, &tc.Name, &tc.Description, &jsonData, &tc.EntityID, &tc.ProjectID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tc.JSONData = jsonData
+		cases = append(cases, tc)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cases)
+}
+
+func linkRequirementTestCases(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid requirement ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		TestCaseIDs []uuid.UUID `json:"test_case_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var projectID uuid.UUID
+	err = db.QueryRow(`SELECT project_id FROM requirements WHERE id = $1`, id).Scan(&projectID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Requirement not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var found int
+	err = db.QueryRow(`SELECT COUNT(*) FROM test_cases WHERE id = ANY($1) AND project_id = $2 AND deleted_at IS NULL`,
+		pq.Array(body.TestCaseIDs), projectID).Scan(&found)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if found != len(uniqueIDs(body.TestCaseIDs)) {
+		http.Error(w, "Test cases must exist in the requirement's project", http.StatusBadRequest)
+		return
+	}
+
+	err = withLinkedVersions(func(tx *sql.Tx) ([]uuid.UUID, error) {
+		return execReturningIDs(tx, `
+			INSERT INTO test_case_requirements (test_case_id, requirement_id)
+			SELECT unnest($1::uuid[]), $2
+			ON CONFLICT DO NOTHING
+			RETURNING test_case_id
+		`, pq.Array(body.TestCaseIDs), id)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func unlinkRequirementTestCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid requirement ID", http.StatusBadRequest)
+		return
+	}
+	tcID, err := uuid.Parse(ps.ByName("testCaseId"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	err = withLinkedVersions(func(tx *sql.Tx) ([]uuid.UUID, error) {
+		unlinked, err := execReturningIDs(tx, `
+			DELETE FROM test_case_requirements WHERE requirement_id = $1 AND test_case_id = $2
+			RETURNING test_case_id
+		`, id, tcID)
+		if err == nil && len(unlinked) == 0 {
+			err = sql.ErrNoRows
+		}
+		return unlinked, err
+	})
+	if err == sql.ErrNoRows {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateRequirementLinks checks that every requirement referenced by the
+// cases exists in the same project as the case.
+func validateRequirementLinks(tx *sql.Tx, cases []TestCase) error {
+	for _, tc := range cases {
+		if len(tc.RequirementIDs) == 0 {
+			continue
+		}
+		ids := uniqueIDs(tc.RequirementIDs)
+
+		var found int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM requirements WHERE id = ANY($1) AND project_id = $2`,
+			pq.Array(ids), tc.ProjectID).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if found != len(ids) {
+			return validationError(fmt.Sprintf("test case %s references requirements that do not exist in its project", tc.ID))
+		}
+	}
+	return nil
+}
+
+func saveRequirementLinks(tx *sql.Tx, tc TestCase) error {
+	if _, err := tx.Exec(`DELETE FROM test_case_requirements WHERE test_case_id = $1`, tc.ID); err != nil {
+		return err
+	}
+	if len(tc.RequirementIDs) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(`
+		INSERT INTO test_case_requirements (test_case_id, requirement_id)
+		SELECT $1, unnest($2::uuid[])
+		ON CONFLICT DO NOTHING
+	`, tc.ID, pq.Array(uniqueIDs(tc.RequirementIDs)))
+	return err
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	var out []uuid.UUID
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
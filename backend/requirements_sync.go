@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"zis/internal/config"
+	"zis/internal/requirements"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+var (
+	requirementsProvider requirements.Provider
+	// requirementsProjects maps local projects to the provider's project keys.
+	requirementsProjects map[uuid.UUID]string
+)
+
+func initRequirementsProvider() {
+	cfg := config.GetConfig()
+
+	requirementsProjects = make(map[uuid.UUID]string)
+	for local, remote := range cfg.Requirements.Projects {
+		id, err := uuid.Parse(local)
+		if err != nil {
+			log.Fatalf("Invalid project ID %q in requirements.projects: %v", local, err)
+		}
+		requirementsProjects[id] = remote
+	}
+
+	switch cfg.Requirements.Provider {
+	case "", "native":
+		requirementsProvider = requirements.Postgres{DB: db}
+	case "rest":
+		if cfg.Requirements.BaseURL == "" {
+			log.Fatal("requirements.base_url is required for the rest provider")
+		}
+		requirementsProvider = requirements.REST{
+			Client:      &http.Client{Timeout: cfg.Requirements.Timeout},
+			BaseURL:     cfg.Requirements.BaseURL,
+			ListPath:    cfg.Requirements.ListPath,
+			VerdictPath: cfg.Requirements.VerdictPath,
+			AuthHeader:  cfg.Requirements.AuthHeader,
+			AuthToken:   cfg.Requirements.AuthToken,
+			Fields:      restFields(cfg.Requirements.Fields),
+			Statuses:    cfg.Requirements.Statuses,
+		}
+	default:
+		log.Fatalf("Unknown requirements provider %q", cfg.Requirements.Provider)
+	}
+}
+
+func restFields(m map[string]string) requirements.Fields {
+	fields := requirements.DefaultFields
+	for name, path := range m {
+		switch name {
+		case "items":
+			fields.Items = path
+		case "id":
+			fields.ID = path
+		case "key":
+			fields.Key = path
+		case "title":
+			fields.Title = path
+		case "description":
+			fields.Description = path
+		case "status":
+			fields.Status = path
+		default:
+			log.Printf("Ignoring unknown requirements field mapping %q", name)
+		}
+	}
+	return fields
+}
+
+// externalRequirements reports whether requirements are owned by a system
+// other than this backend and therefore need syncing.
+func externalRequirements() bool {
+	_, native := requirementsProvider.(requirements.Postgres)
+	return !native
+}
+
+func startRequirementsSync(ctx context.Context) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	if !externalRequirements() || len(requirementsProjects) == 0 {
+		return &wg
+	}
+
+	cfg := config.GetConfig()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(cfg.Requirements.SyncInterval)
+		defer ticker.Stop()
+
+		for {
+			for projectID, remote := range requirementsProjects {
+				n, err := syncProjectRequirements(ctx, projectID, remote)
+				if err != nil {
+					log.Printf("Requirements sync for project %s failed: %v", projectID, err)
+					continue
+				}
+				log.Printf("Synced %d requirements for project %s from %s", n, projectID, requirementsProvider.Name())
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return &wg
+}
+
+// syncProjectRequirements upserts the provider's requirements into the
+// local store, matching on external ID. Local copies that disappeared
+// remotely are deprecated rather than deleted so their links survive.
+// Requirements whose key is already taken by another local requirement are
+// logged and skipped; the returned count covers only the stored ones.
+func syncProjectRequirements(ctx context.Context, projectID uuid.UUID, remote string) (int, error) {
+	remoteReqs, err := requirementsProvider.List(ctx, remote)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	synced := 0
+	externalIDs := make([]string, 0, len(remoteReqs))
+	for _, r := range remoteReqs {
+		key := r.Key
+		if key == "" {
+			key = r.ExternalID
+		}
+		status := r.Status
+		switch status {
+		case requirementStatusDraft, requirementStatusActive, requirementStatusDeprecated:
+		default:
+			status = requirementStatusDraft
+		}
+		title := r.Title
+		if title == "" {
+			title = key
+		}
+
+		// Remote keys may collide with local requirements; the savepoint lets
+		// such a row be skipped without aborting the rest of the sync.
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT sync_requirement`); err != nil {
+			return 0, err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO requirements (id, key, title, description, status, project_id, external_id, synced_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+			ON CONFLICT (project_id, external_id) DO UPDATE SET
+				key = EXCLUDED.key, title = EXCLUDED.title, description = EXCLUDED.description,
+				status = EXCLUDED.status, synced_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		`, uuid.New(), key, title, r.Description, status, projectID, r.ExternalID)
+		// The external ID is kept even when skipped so an existing copy is
+		// not deprecated because of the conflict.
+		externalIDs = append(externalIDs, r.ExternalID)
+		var pqErr *pq.Error
+		switch {
+		case err == nil:
+			synced++
+		case errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "requirements_project_key_unique":
+			log.Printf("Skipping requirement %s of project %s: key %s is already in use", r.ExternalID, projectID, key)
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT sync_requirement`); err != nil {
+				return 0, err
+			}
+			continue
+		default:
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT sync_requirement`); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE requirements SET status = $3, synced_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE project_id = $1 AND external_id IS NOT NULL AND NOT (external_id = ANY($2)) AND status <> $3
+	`, projectID, pq.Array(externalIDs), requirementStatusDeprecated)
+	if err != nil {
+		return 0, err
+	}
+
+	return synced, tx.Commit()
+}
+
+func syncRequirements(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	remote, ok := requirementsProjects[projectID]
+	if !externalRequirements() || !ok {
+		http.Error(w, "Project is not linked to an external requirements provider", http.StatusBadRequest)
+		return
+	}
+
+	n, err := syncProjectRequirements(r.Context(), projectID, remote)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"project_id": projectID,
+		"provider":   requirementsProvider.Name(),
+		"synced":     n,
+	})
+}
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"zis/internal/executor"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+const (
+	runStatusQueued    = "queued"
+	runStatusRunning   = "running"
+	runStatusFinished  = "finished"
+	runStatusError     = "error"
+	runStatusCancelled = "cancelled"
+
+	runModeAutomated = "automated"
+	runModeManual    = "manual"
+
+	// resultStatusBlocked is only ever reported by manual testers.
+	resultStatusBlocked = "blocked"
+)
+
+type TestRun struct {
+	ID            uuid.UUID           `json:"id"`
+	StartedBy     string              `json:"started_by"`
+	Mode          string              `json:"mode"`
+	Status        string              `json:"status"`
+	Message       string              `json:"message,omitempty"`
+	TestCaseIDs   []uuid.UUID         `json:"test_case_ids"`
+	EnvironmentID *uuid.UUID          `json:"environment_id,omitempty"`
+	SuiteID       *uuid.UUID          `json:"suite_id,omitempty"`
+	Total         int                 `json:"total"`
+	Passed        int                 `json:"passed"`
+	Failed        int                 `json:"failed"`
+	Errors        int                 `json:"errors"`
+	Skipped       int                 `json:"skipped"`
+	Blocked       int                 `json:"blocked"`
+	CreatedAt     time.Time           `json:"created_at"`
+	StartedAt     *time.Time          `json:"started_at,omitempty"`
+	FinishedAt    *time.Time          `json:"finished_at,omitempty"`
+	Results       []TestCaseRunResult `json:"results,omitempty"`
+}
+
+type TestRunProgress struct {
+	ID        uuid.UUID `json:"id"`
+	Status    string    `json:"status"`
+	Total     int       `json:"total"`
+	Completed int       `json:"completed"`
+	Passed    int       `json:"passed"`
+	Failed    int       `json:"failed"`
+	Errors    int       `json:"errors"`
+	Skipped   int       `json:"skipped"`
+	Blocked   int       `json:"blocked"`
+}
+
+type TestRunAttempt struct {
+	Attempt    int             `json:"attempt"`
+	Status     string          `json:"status"`
+	Message    string          `json:"message,omitempty"`
+	Output     json.RawMessage `json:"output,omitempty"`
+	DurationMs int64           `json:"duration_ms"`
+	StartedAt  time.Time       `json:"started_at"`
+}
+
+type TestRunIteration struct {
+	Iteration  int                    `json:"iteration"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Status     string                 `json:"status"`
+	Message    string                 `json:"message,omitempty"`
+	Output     json.RawMessage        `json:"output,omitempty"`
+	DurationMs int64                  `json:"duration_ms"`
+	Attempts   int                    `json:"attempts"`
+	AttemptLog []TestRunAttempt       `json:"attempt_log,omitempty"`
+}
+
+const runColumns = `id, COALESCE(started_by, ''), mode, status, COALESCE(message, ''), test_case_ids, environment_id, suite_id,
+	total, passed, failed, errors, skipped, blocked, created_at, started_at, finished_at`
+
+const runResultColumns = `id, run_id, test_case_id, status, COALESCE(message, ''), output, duration_ms, attempts,
+	COALESCE(test_case_version, 0), run_time`
+
+func scanRun(row interface{ Scan(...interface{}) error }) (TestRun, error) {
+	var run TestRun
+	var startedAt, finishedAt sql.NullTime
+	var tcIDs []string
+	var envID, suiteID uuid.NullUUID
+	err := row.Scan(&run.ID, &run.StartedBy, &run.Mode, &run.Status, &run.Message, pq.Array(&tcIDs), &envID, &suiteID,
+		&run.Total, &run.Passed, &run.Failed, &run.Errors, &run.Skipped, &run.Blocked, &run.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return run, err
+	}
+
+	run.TestCaseIDs = make([]uuid.UUID, 0, len(tcIDs))
+	for _, id := range tcIDs {
+		tcID, err := uuid.Parse(id)
+		if err != nil {
+			return run, err
+		}
+		run.TestCaseIDs = append(run.TestCaseIDs, tcID)
+	}
+	if envID.Valid {
+		run.EnvironmentID = &envID.UUID
+	}
+	if suiteID.Valid {
+		run.SuiteID = &suiteID.UUID
+	}
+	if startedAt.Valid {
+		run.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		run.FinishedAt = &finishedAt.Time
+	}
+	return run, nil
+}
+
+func scanRunResult(row interface{ Scan(...interface{}) error }) (TestCaseRunResult, error) {
+	var result TestCaseRunResult
+	var output []byte
+	err := row.Scan(&result.ID, &result.RunID, &result.TestCaseID, &result.Status, &result.Message,
+		&output, &result.DurationMs, &result.Attempts, &result.Version, &result.RunTime)
+	if len(output) > 0 {
+		result.Output = output
+	}
+	result.Flaky = result.Status == executor.StatusPassed && result.Attempts > 1
+	return result, err
+}
+
+func nullJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return []byte(data)
+}
+
+func enqueueRun(run *TestRun) error {
+	return db.QueryRow(`
+		INSERT INTO test_runs (id, started_by, mode, status, test_case_ids, environment_id, suite_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at
+	`, run.ID, run.StartedBy, run.Mode, run.Status, pq.Array(run.TestCaseIDs), run.EnvironmentID, run.SuiteID).Scan(&run.CreatedAt)
+}
+
+// claimRun atomically moves the oldest queued run to running. SKIP LOCKED lets
+// several backend replicas poll the same table without picking the same run.
+// The claim is a lease that the owner keeps renewing; a running run whose
+// lease ran out belongs to a replica that died and is claimed again.
+func claimRun(lease time.Duration) (*TestRun, error) {
+	run, err := scanRun(db.QueryRow(`
+		UPDATE test_runs SET status = $1, started_at = COALESCE(started_at, CURRENT_TIMESTAMP),
+			claimed_until = CURRENT_TIMESTAMP + $4::double precision * INTERVAL '1 millisecond'
+		WHERE id = (
+			SELECT id FROM test_runs
+			WHERE status = $2 OR (status = $1 AND mode = $3 AND claimed_until < CURRENT_TIMESTAMP)
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING `+runColumns, runStatusRunning, runStatusQueued, runModeAutomated, lease.Milliseconds()))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func setRunTotal(run *TestRun, total int) error {
+	run.Total = total
+	_, err := db.Exec(`UPDATE test_runs SET total = $2 WHERE id = $1`, run.ID, total)
+	return err
+}
+
+// recordRunResult stores a case result together with the notifications it
+// causes, in one transaction.
+func recordRunResult(run *TestRun, tc TestCase, result TestCaseRunResult) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if result.ID == uuid.Nil {
+		result.ID = uuid.New()
+	}
+	result.Attempts = max(len(result.AttemptLog), 1)
+	result.Flaky = result.Status == executor.StatusPassed && result.Attempts > 1
+	result.Version = tc.Version
+
+	_, err = tx.Exec(`
+		INSERT INTO test_run_results (id, run_id, test_case_id, status, message, output, duration_ms, attempts,
+			test_case_version, run_time)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10)
+	`, result.ID, run.ID, result.TestCaseID, result.Status, result.Message,
+		nullJSON(result.Output), result.DurationMs, result.Attempts, result.Version, result.RunTime)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range result.AttemptLog {
+		_, err = tx.Exec(`
+			INSERT INTO test_run_attempts (id, result_id, attempt, status, message, output, duration_ms, started_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, uuid.New(), result.ID, a.Attempt, a.Status, a.Message, nullJSON(a.Output), a.DurationMs, a.StartedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, it := range result.Iterations {
+		params, err := json.Marshal(it.Parameters)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO test_run_iterations (id, result_id, iteration, parameters, status, message, output, duration_ms, attempts)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`, uuid.New(), result.ID, it.Iteration, params, it.Status, it.Message, nullJSON(it.Output), it.DurationMs, it.Attempts)
+		if err != nil {
+			return err
+		}
+
+		for _, a := range it.AttemptLog {
+			_, err = tx.Exec(`
+				INSERT INTO test_run_attempts (id, result_id, iteration, attempt, status, message, output, duration_ms, started_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			`, uuid.New(), result.ID, it.Iteration, a.Attempt, a.Status, a.Message, nullJSON(a.Output), a.DurationMs, a.StartedAt)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE test_runs SET
+			passed = passed + CASE WHEN $2 = $3 THEN 1 ELSE 0 END,
+			failed = failed + CASE WHEN $2 = $4 THEN 1 ELSE 0 END,
+			skipped = skipped + CASE WHEN $2 = $5 THEN 1 ELSE 0 END,
+			blocked = blocked + CASE WHEN $2 = $6 THEN 1 ELSE 0 END,
+			errors = errors + CASE WHEN $2 NOT IN ($3, $4, $5, $6) THEN 1 ELSE 0 END
+		WHERE id = $1
+	`, run.ID, result.Status, executor.StatusPassed, executor.StatusFailed, executor.StatusSkipped, resultStatusBlocked)
+	if err != nil {
+		return err
+	}
+
+	if err := sendNotification(tx, run, tc, result); err != nil {
+		return err
+	}
+
+	counted := *run
+	switch result.Status {
+	case executor.StatusPassed:
+		counted.Passed++
+	case executor.StatusFailed:
+		counted.Failed++
+	case executor.StatusSkipped:
+		counted.Skipped++
+	case resultStatusBlocked:
+		counted.Blocked++
+	default:
+		counted.Errors++
+	}
+
+	ev := newRunEvent(runEventCaseFinished, &counted)
+	ev.TestCaseID = &result.TestCaseID
+	ev.Status = result.Status
+	ev.Message = result.Message
+	ev.DurationMs = result.DurationMs
+	notifyRunEventTx(tx, ev)
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	run.Passed, run.Failed, run.Errors, run.Skipped = counted.Passed, counted.Failed, counted.Errors, counted.Skipped
+	run.Blocked = counted.Blocked
+	run.Results = append(run.Results, result)
+	return nil
+}
+
+// finishRun closes the run and queues run.finished for every project the
+// run touched.
+func finishRun(run *TestRun, status, message string, projectIDs []uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	finishedAt := time.Now()
+	_, err = tx.Exec(`UPDATE test_runs SET status = $2, message = NULLIF($3, ''), finished_at = $4 WHERE id = $1`,
+		run.ID, status, message, finishedAt)
+	if err != nil {
+		return err
+	}
+
+	run.Status = status
+	run.Message = message
+	run.FinishedAt = &finishedAt
+	if err := notifyRunFinished(tx, run, projectIDs); err != nil {
+		return err
+	}
+	notifyRunEventTx(tx, newRunEvent(runEventRunFinished, run))
+
+	return tx.Commit()
+}
+
+func queryRunResults(query string, args ...interface{}) ([]TestCaseRunResult, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []TestCaseRunResult{}
+	for rows.Next() {
+		result, err := scanRunResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+func loadRunAttempts(results []TestCaseRunResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	index := make(map[uuid.UUID]*TestCaseRunResult, len(results))
+	ids := make([]uuid.UUID, len(results))
+	for i := range results {
+		index[results[i].ID] = &results[i]
+		ids[i] = results[i].ID
+	}
+
+	rows, err := db.Query(`
+		SELECT result_id, attempt, status, COALESCE(message, ''), output, duration_ms, started_at
+		FROM test_run_attempts WHERE result_id = ANY($1) AND iteration = 0 ORDER BY result_id, attempt
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var resultID uuid.UUID
+		var a TestRunAttempt
+		var output []byte
+		if err := rows.Scan(&resultID, &a.Attempt, &a.Status, &a.Message, &output, &a.DurationMs, &a.StartedAt); err != nil {
+			return err
+		}
+		if len(output) > 0 {
+			a.Output = output
+		}
+		if res, ok := index[resultID]; ok {
+			res.AttemptLog = append(res.AttemptLog, a)
+		}
+	}
+	return rows.Err()
+}
+
+func loadRunIterations(results []TestCaseRunResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	index := make(map[uuid.UUID]*TestCaseRunResult, len(results))
+	ids := make([]uuid.UUID, len(results))
+	for i := range results {
+		index[results[i].ID] = &results[i]
+		ids[i] = results[i].ID
+	}
+
+	rows, err := db.Query(`
+		SELECT result_id, iteration, parameters, status, COALESCE(message, ''), output, duration_ms, attempts
+		FROM test_run_iterations WHERE result_id = ANY($1) ORDER BY result_id, iteration
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var resultID uuid.UUID
+		var it TestRunIteration
+		var params, output []byte
+		if err := rows.Scan(&resultID, &it.Iteration, &params, &it.Status, &it.Message, &output, &it.DurationMs, &it.Attempts); err != nil {
+			return err
+		}
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &it.Parameters); err != nil {
+				return err
+			}
+		}
+		if len(output) > 0 {
+			it.Output = output
+		}
+		if res, ok := index[resultID]; ok {
+			res.Iterations = append(res.Iterations, it)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return loadIterationAttempts(ids, index)
+}
+
+// loadIterationAttempts fills in the attempt logs of parameterized
+// iterations, which are stored with their iteration number.
+func loadIterationAttempts(ids []uuid.UUID, index map[uuid.UUID]*TestCaseRunResult) error {
+	rows, err := db.Query(`
+		SELECT result_id, iteration, attempt, status, COALESCE(message, ''), output, duration_ms, started_at
+		FROM test_run_attempts WHERE result_id = ANY($1) AND iteration > 0 ORDER BY result_id, iteration, attempt
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var resultID uuid.UUID
+		var iteration int
+		var a TestRunAttempt
+		var output []byte
+		if err := rows.Scan(&resultID, &iteration, &a.Attempt, &a.Status, &a.Message, &output, &a.DurationMs, &a.StartedAt); err != nil {
+			return err
+		}
+		if len(output) > 0 {
+			a.Output = output
+		}
+		res, ok := index[resultID]
+		if !ok {
+			continue
+		}
+		for i := range res.Iterations {
+			if res.Iterations[i].Iteration == iteration {
+				res.Iterations[i].AttemptLog = append(res.Iterations[i].AttemptLog, a)
+				break
+			}
+		}
+	}
+	return rows.Err()
+}
+
+func listRuns(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var envID *uuid.UUID
+	if v := r.URL.Query().Get("environment_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "Invalid environment ID", http.StatusBadRequest)
+			return
+		}
+		envID = &id
+	}
+
+	var suiteID *uuid.UUID
+	if v := r.URL.Query().Get("suite_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			http.Error(w, "Invalid suite ID", http.StatusBadRequest)
+			return
+		}
+		suiteID = &id
+	}
+
+	rows, err := db.Query(`
+		SELECT `+runColumns+` FROM test_runs
+		WHERE ($2::uuid IS NULL OR environment_id = $2) AND ($3::uuid IS NULL OR suite_id = $3)
+		ORDER BY created_at DESC LIMIT $1
+	`, limit, envID, suiteID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	runs := []TestRun{}
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		runs = append(runs, run)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+func getRun(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	runID, err := uuid.Parse(ps.ByName("runId"))
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	run, err := scanRun(db.QueryRow(`SELECT `+runColumns+` FROM test_runs WHERE id = $1`, runID))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	run.Results, err = queryRunResults(`SELECT `+runResultColumns+` FROM test_run_results WHERE run_id = $1 ORDER BY run_time`, runID)
+	if err == nil {
+		err = loadRunAttempts(run.Results)
+	}
+	if err == nil {
+		err = loadRunIterations(run.Results)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(run)
+}
+
+func getTestCaseHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tcID, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	results, err := queryRunResults(`SELECT `+runResultColumns+` FROM test_run_results WHERE test_case_id = $1 ORDER BY run_time DESC`, tcID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func getRunStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	runID, err := uuid.Parse(ps.ByName("runId"))
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	var p TestRunProgress
+	err = db.QueryRow(`SELECT id, status, total, passed, failed, errors, skipped, blocked FROM test_runs WHERE id = $1`, runID).
+		Scan(&p.ID, &p.Status, &p.Total, &p.Passed, &p.Failed, &p.Errors, &p.Skipped, &p.Blocked)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	p.Completed = p.Passed + p.Failed + p.Errors + p.Skipped + p.Blocked
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+func cancelRun(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	runID, err := uuid.Parse(ps.ByName("runId"))
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	// Queued and manual runs are cancelled right away, since no worker owns
+	// them. Running ones are flagged so that whichever replica owns the run
+	// notices and stops it.
+	var status string
+	err = db.QueryRow(`
+		UPDATE test_runs SET
+			cancel_requested = TRUE,
+			status = CASE WHEN status = $2 OR mode = $5 THEN $3 ELSE status END,
+			finished_at = CASE WHEN status = $2 OR mode = $5 THEN CURRENT_TIMESTAMP ELSE finished_at END
+		WHERE id = $1 AND status IN ($2, $4)
+		RETURNING status
+	`, runID, runStatusQueued, runStatusCancelled, runStatusRunning, runModeManual).Scan(&status)
+	if err == sql.ErrNoRows {
+		var exists bool
+		if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM test_runs WHERE id = $1)`, runID).Scan(&exists); err != nil || !exists {
+			http.Error(w, "Run not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Run is already finished", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if status == runStatusRunning {
+		runWorkers.cancel(runID)
+	} else {
+		ev := RunEvent{Type: runEventRunFinished, RunID: runID, Status: status}
+		if err := notifyRunEvent(db, ev); err != nil {
+			log.Printf("Failed to publish cancellation of run %s: %v", runID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": runID.String(), "status": status})
+}
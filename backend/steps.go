@@ -0,0 +1,533 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+// TestStep is one step of a test case. A step that references a shared
+// step takes its action, test data and expected result from it, so editing
+// the shared step changes every case using it.
+type TestStep struct {
+	ID             uuid.UUID  `json:"id"`
+	Position       int        `json:"position"`
+	Action         string     `json:"action"`
+	TestData       string     `json:"test_data,omitempty"`
+	ExpectedResult string     `json:"expected_result,omitempty"`
+	SharedStepID   *uuid.UUID `json:"shared_step_id,omitempty"`
+}
+
+type SharedStep struct {
+	ID             uuid.UUID `json:"id"`
+	ProjectID      uuid.UUID `json:"project_id"`
+	Name           string    `json:"name"`
+	Action         string    `json:"action"`
+	TestData       string    `json:"test_data,omitempty"`
+	ExpectedResult string    `json:"expected_result,omitempty"`
+	UsedBy         int       `json:"used_by"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+const sharedStepColumns = `id, project_id, name, action, COALESCE(test_data, ''), COALESCE(expected_result, ''),
+	(SELECT COUNT(*) FROM test_case_steps WHERE shared_step_id = shared_steps.id), created_at, updated_at`
+
+func scanSharedStep(row interface{ Scan(...interface{}) error }) (SharedStep, error) {
+	var s SharedStep
+	err := row.Scan(&s.ID, &s.ProjectID, &s.Name, &s.Action, &s.TestData, &s.ExpectedResult, &s.UsedBy,
+		&s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// loadTestCaseSteps returns the expanded steps of the given cases in order.
+func loadTestCaseSteps(ids []uuid.UUID) (map[uuid.UUID][]TestStep, error) {
+	rows, err := db.Query(`
+		SELECT s.test_case_id, s.id, s.position,
+			CASE WHEN s.shared_step_id IS NULL THEN s.action ELSE ss.action END,
+			COALESCE(CASE WHEN s.shared_step_id IS NULL THEN s.test_data ELSE ss.test_data END, ''),
+			COALESCE(CASE WHEN s.shared_step_id IS NULL THEN s.expected_result ELSE ss.expected_result END, ''),
+			s.shared_step_id
+		FROM test_case_steps s LEFT JOIN shared_steps ss ON ss.id = s.shared_step_id
+		WHERE s.test_case_id = ANY($1)
+		ORDER BY s.test_case_id, s.position
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := make(map[uuid.UUID][]TestStep)
+	for rows.Next() {
+		var tcID uuid.UUID
+		var s TestStep
+		var sharedID uuid.NullUUID
+		if err := rows.Scan(&tcID, &s.ID, &s.Position, &s.Action, &s.TestData, &s.ExpectedResult, &sharedID); err != nil {
+			return nil, err
+		}
+		if sharedID.Valid {
+			s.SharedStepID = &sharedID.UUID
+		}
+		steps[tcID] = append(steps[tcID], s)
+	}
+	return steps, rows.Err()
+}
+
+// validateSteps checks that every step has an action of its own or a
+// shared step from the case's project.
+func validateSteps(tx *sql.Tx, projectID uuid.UUID, steps []TestStep) error {
+	var shared []uuid.UUID
+	for i, s := range steps {
+		if s.SharedStepID != nil {
+			shared = append(shared, *s.SharedStepID)
+			continue
+		}
+		if s.Action == "" {
+			return validationError(fmt.Sprintf("step %d needs an action or a shared_step_id", i+1))
+		}
+	}
+	if len(shared) == 0 {
+		return nil
+	}
+
+	shared = uniqueIDs(shared)
+	var found int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM shared_steps WHERE id = ANY($1) AND project_id = $2`,
+		pq.Array(shared), projectID).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(shared) {
+		return validationError("Shared steps must exist in the test case's project")
+	}
+	return nil
+}
+
+func insertStep(tx *sql.Tx, tcID uuid.UUID, s TestStep) error {
+	if s.SharedStepID != nil {
+		s.Action, s.TestData, s.ExpectedResult = "", "", ""
+	}
+	_, err := tx.Exec(`
+		INSERT INTO test_case_steps (id, test_case_id, position, action, test_data, expected_result, shared_step_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7)
+	`, s.ID, tcID, s.Position, s.Action, s.TestData, s.ExpectedResult, s.SharedStepID)
+	return err
+}
+
+// saveSteps replaces the steps of a case, numbering them in list order.
+func saveSteps(tx *sql.Tx, tc TestCase) error {
+	if err := validateSteps(tx, tc.ProjectID, tc.Steps); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM test_case_steps WHERE test_case_id = $1`, tc.ID); err != nil {
+		return err
+	}
+	for i, s := range tc.Steps {
+		if s.ID == uuid.Nil {
+			s.ID = uuid.New()
+		}
+		s.Position = i + 1
+		if err := insertStep(tx, tc.ID, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStepsError(w http.ResponseWriter, err error) {
+	var verr validationError
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Test case not found", http.StatusNotFound)
+	case errors.As(err, &verr):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// withTestCaseSteps runs fn in a transaction holding the test case row, so
+// concurrent step edits of one case are serialized, stores the result as a
+// new version of the case and then responds with the case's steps.
+func withTestCaseSteps(w http.ResponseWriter, ps httprouter.Params, fn func(tx *sql.Tx, tcID, projectID uuid.UUID) error) {
+	tcID, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var projectID uuid.UUID
+	err = tx.QueryRow(`SELECT project_id FROM test_cases WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, tcID).Scan(&projectID)
+	if err == nil {
+		err = fn(tx, tcID, projectID)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE test_cases SET version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, tcID)
+	}
+	if err == nil {
+		err = snapshotTestCase(tx, tcID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		writeStepsError(w, err)
+		return
+	}
+
+	writeTestCaseSteps(w, tcID)
+}
+
+func writeTestCaseSteps(w http.ResponseWriter, tcID uuid.UUID) {
+	steps, err := loadTestCaseSteps([]uuid.UUID{tcID})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	list := steps[tcID]
+	if list == nil {
+		list = []TestStep{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func listTestCaseSteps(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tcID, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM test_cases WHERE id = $1 AND deleted_at IS NULL)`, tcID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Test case not found", http.StatusNotFound)
+		return
+	}
+
+	writeTestCaseSteps(w, tcID)
+}
+
+func replaceTestCaseSteps(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var steps []TestStep
+	if err := json.NewDecoder(r.Body).Decode(&steps); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	withTestCaseSteps(w, ps, func(tx *sql.Tx, tcID, projectID uuid.UUID) error {
+		return saveSteps(tx, TestCase{ID: tcID, ProjectID: projectID, Steps: steps})
+	})
+}
+
+// putTestCaseStep creates or updates the step with the given ID. A new step
+// is inserted at its position (1-based), shifting the following steps down;
+// without a position it is appended. Giving an existing step another
+// position moves it there.
+func putTestCaseStep(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	stepID, err := uuid.Parse(ps.ByName("stepId"))
+	if err != nil {
+		http.Error(w, "Invalid step ID", http.StatusBadRequest)
+		return
+	}
+
+	var step TestStep
+	if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	step.ID = stepID
+
+	withTestCaseSteps(w, ps, func(tx *sql.Tx, tcID, projectID uuid.UUID) error {
+		if err := validateSteps(tx, projectID, []TestStep{step}); err != nil {
+			return err
+		}
+
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM test_case_steps WHERE test_case_id = $1`, tcID).Scan(&count); err != nil {
+			return err
+		}
+		var current int
+		err := tx.QueryRow(`SELECT position FROM test_case_steps WHERE id = $1 AND test_case_id = $2`,
+			stepID, tcID).Scan(&current)
+		if err == sql.ErrNoRows {
+			var taken bool
+			if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM test_case_steps WHERE id = $1)`, stepID).Scan(&taken); err != nil {
+				return err
+			}
+			if taken {
+				return validationError("Step belongs to another test case")
+			}
+			if step.Position < 1 || step.Position > count+1 {
+				step.Position = count + 1
+			}
+			_, err = tx.Exec(`UPDATE test_case_steps SET position = position + 1 WHERE test_case_id = $1 AND position >= $2`,
+				tcID, step.Position)
+			if err != nil {
+				return err
+			}
+			return insertStep(tx, tcID, step)
+		}
+		if err != nil {
+			return err
+		}
+
+		if step.Position < 1 || step.Position > count {
+			step.Position = current
+		}
+		if step.SharedStepID != nil {
+			step.Action, step.TestData, step.ExpectedResult = "", "", ""
+		}
+		_, err = tx.Exec(`
+			UPDATE test_case_steps SET position = CASE
+				WHEN id = $2 THEN $4
+				WHEN $4 < $3 AND position >= $4 AND position < $3 THEN position + 1
+				WHEN $4 > $3 AND position > $3 AND position <= $4 THEN position - 1
+				ELSE position END
+			WHERE test_case_id = $1
+		`, tcID, stepID, current, step.Position)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE test_case_steps SET action = NULLIF($2, ''), test_data = NULLIF($3, ''),
+				expected_result = NULLIF($4, ''), shared_step_id = $5
+			WHERE id = $1
+		`, stepID, step.Action, step.TestData, step.ExpectedResult, step.SharedStepID)
+		return err
+	})
+}
+
+func deleteTestCaseStep(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	stepID, err := uuid.Parse(ps.ByName("stepId"))
+	if err != nil {
+		http.Error(w, "Invalid step ID", http.StatusBadRequest)
+		return
+	}
+
+	withTestCaseSteps(w, ps, func(tx *sql.Tx, tcID, _ uuid.UUID) error {
+		var position int
+		err := tx.QueryRow(`DELETE FROM test_case_steps WHERE id = $1 AND test_case_id = $2 RETURNING position`,
+			stepID, tcID).Scan(&position)
+		if err == sql.ErrNoRows {
+			return validationError("Step not found in test case")
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE test_case_steps SET position = position - 1 WHERE test_case_id = $1 AND position > $2`,
+			tcID, position)
+		return err
+	})
+}
+
+// reorderTestCaseSteps takes every step ID of the case in the new order.
+func reorderTestCaseSteps(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	var body struct {
+		StepIDs []uuid.UUID `json:"step_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	withTestCaseSteps(w, ps, func(tx *sql.Tx, tcID, _ uuid.UUID) error {
+		var count, matched int
+		err := tx.QueryRow(`
+			SELECT COUNT(*), COUNT(*) FILTER (WHERE id = ANY($2))
+			FROM test_case_steps WHERE test_case_id = $1
+		`, tcID, pq.Array(body.StepIDs)).Scan(&count, &matched)
+		if err != nil {
+			return err
+		}
+		if len(uniqueIDs(body.StepIDs)) != len(body.StepIDs) || count != len(body.StepIDs) || matched != count {
+			return validationError("step_ids must list every step of the test case exactly once")
+		}
+
+		_, err = tx.Exec(`
+			UPDATE test_case_steps s SET position = t.position
+			FROM unnest($2::uuid[]) WITH ORDINALITY AS t(id, position)
+			WHERE s.id = t.id AND s.test_case_id = $1
+		`, tcID, pq.Array(body.StepIDs))
+		return err
+	})
+}
+
+func createSharedStep(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var step SharedStep
+	if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if step.Name == "" || step.Action == "" {
+		http.Error(w, "Shared steps need a name and an action", http.StatusBadRequest)
+		return
+	}
+	if step.ID == uuid.Nil {
+		step.ID = uuid.New()
+	}
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1)", projectID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	created, err := scanSharedStep(db.QueryRow(`
+		INSERT INTO shared_steps (id, project_id, name, action, test_data, expected_result)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''))
+		RETURNING `+sharedStepColumns,
+		step.ID, projectID, step.Name, step.Action, step.TestData, step.ExpectedResult))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+func listSharedSteps(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT `+sharedStepColumns+` FROM shared_steps WHERE project_id = $1 ORDER BY name`, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	steps := []SharedStep{}
+	for rows.Next() {
+		s, err := scanSharedStep(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		steps = append(steps, s)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(steps)
+}
+
+func getSharedStep(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid shared step ID", http.StatusBadRequest)
+		return
+	}
+
+	step, err := scanSharedStep(db.QueryRow(`SELECT `+sharedStepColumns+` FROM shared_steps WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Shared step not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(step)
+}
+
+func updateSharedStep(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid shared step ID", http.StatusBadRequest)
+		return
+	}
+
+	var step SharedStep
+	if err := json.NewDecoder(r.Body).Decode(&step); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if step.Name == "" || step.Action == "" {
+		http.Error(w, "Shared steps need a name and an action", http.StatusBadRequest)
+		return
+	}
+
+	updated, err := scanSharedStep(db.QueryRow(`
+		UPDATE shared_steps SET name = $2, action = $3, test_data = NULLIF($4, ''), expected_result = NULLIF($5, ''),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+sharedStepColumns,
+		id, step.Name, step.Action, step.TestData, step.ExpectedResult))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Shared step not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// deleteSharedStep refuses to remove a shared step that cases still use.
+func deleteSharedStep(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid shared step ID", http.StatusBadRequest)
+		return
+	}
+
+	var used bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM test_case_steps WHERE shared_step_id = $1)`, id).Scan(&used)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if used {
+		http.Error(w, "Shared step is used by test cases", http.StatusConflict)
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM shared_steps WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Shared step not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+const (
+	suiteKindStatic  = "static"
+	suiteKindDynamic = "dynamic"
+)
+
+// TestSuite is either a static, ordered list of test cases or a dynamic
+// filter that is evaluated whenever the suite is run.
+type TestSuite struct {
+	ID          uuid.UUID       `json:"id"`
+	ProjectID   uuid.UUID       `json:"project_id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Kind        string          `json:"kind"`
+	TestCaseIDs []uuid.UUID     `json:"test_case_ids,omitempty"`
+	Filter      *TestCaseFilter `json:"filter,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+const suiteColumns = `id, project_id, name, COALESCE(description, ''), kind, filter, created_at, updated_at`
+
+func scanSuite(row interface{ Scan(...interface{}) error }) (TestSuite, error) {
+	var s TestSuite
+	var filter []byte
+	err := row.Scan(&s.ID, &s.ProjectID, &s.Name, &s.Description, &s.Kind, &filter, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return s, err
+	}
+	if len(filter) > 0 {
+		s.Filter = &TestCaseFilter{}
+		if err := json.Unmarshal(filter, s.Filter); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+func loadSuite(id uuid.UUID) (TestSuite, error) {
+	suite, err := scanSuite(db.QueryRow(`SELECT `+suiteColumns+` FROM test_suites WHERE id = $1`, id))
+	if err != nil || suite.Kind != suiteKindStatic {
+		return suite, err
+	}
+
+	rows, err := db.Query(`SELECT test_case_id FROM test_suite_cases WHERE suite_id = $1 ORDER BY position`, id)
+	if err != nil {
+		return suite, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tcID uuid.UUID
+		if err := rows.Scan(&tcID); err != nil {
+			return suite, err
+		}
+		suite.TestCaseIDs = append(suite.TestCaseIDs, tcID)
+	}
+	return suite, rows.Err()
+}
+
+// resolveSuite returns the suite's test cases in run order. Dynamic suites
+// are evaluated now, so they pick up cases added since the suite was saved.
+func resolveSuite(suite TestSuite) ([]uuid.UUID, error) {
+	if suite.Kind == suiteKindStatic {
+		return suite.TestCaseIDs, nil
+	}
+
+	f := TestCaseFilter{}
+	if suite.Filter != nil {
+		f = *suite.Filter
+	}
+	f.ProjectID = &suite.ProjectID
+	args, err := f.args()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT tc.id FROM `+testCaseFrom+`
+		WHERE `+testCaseFilterWhere+`
+		ORDER BY tc.created_at, tc.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// validateSuite normalizes the suite and checks that static members belong
+// to its project.
+func validateSuite(tx *sql.Tx, suite *TestSuite) error {
+	if suite.Name == "" {
+		return validationError("Suite name is required")
+	}
+	switch suite.Kind {
+	case "":
+		suite.Kind = suiteKindStatic
+		if suite.Filter != nil {
+			suite.Kind = suiteKindDynamic
+		}
+	case suiteKindStatic, suiteKindDynamic:
+	default:
+		return validationError(fmt.Sprintf("Unknown suite kind %q", suite.Kind))
+	}
+
+	if suite.Kind == suiteKindDynamic {
+		if len(suite.TestCaseIDs) > 0 {
+			return validationError("Dynamic suites take a filter, not test_case_ids")
+		}
+		if suite.Filter == nil {
+			suite.Filter = &TestCaseFilter{}
+		}
+		// The suite's project always applies.
+		suite.Filter.ProjectID = nil
+		if _, err := suite.Filter.args(); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if suite.Filter != nil {
+		return validationError("Static suites take test_case_ids, not a filter")
+	}
+	ids := uniqueIDs(suite.TestCaseIDs)
+	if len(ids) != len(suite.TestCaseIDs) {
+		return validationError("Suite lists a test case more than once")
+	}
+	var found int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM test_cases WHERE id = ANY($1) AND project_id = $2 AND deleted_at IS NULL`,
+		pq.Array(ids), suite.ProjectID).Scan(&found)
+	if err != nil {
+		return err
+	}
+	if found != len(ids) {
+		return validationError("Suite test cases must exist in the suite's project")
+	}
+	return nil
+}
+
+// saveSuite inserts or updates the suite and replaces its membership.
+func saveSuite(suite TestSuite, create bool) (TestSuite, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return suite, err
+	}
+	defer tx.Rollback()
+
+	if err := validateSuite(tx, &suite); err != nil {
+		return suite, err
+	}
+
+	var filter []byte
+	if suite.Filter != nil {
+		if filter, err = json.Marshal(suite.Filter); err != nil {
+			return suite, err
+		}
+	}
+
+	var saved TestSuite
+	if create {
+		saved, err = scanSuite(tx.QueryRow(`
+			INSERT INTO test_suites (id, project_id, name, description, kind, filter)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING `+suiteColumns,
+			suite.ID, suite.ProjectID, suite.Name, suite.Description, suite.Kind, nullJSON(filter)))
+	} else {
+		saved, err = scanSuite(tx.QueryRow(`
+			UPDATE test_suites SET name = $2, description = $3, kind = $4, filter = $5,
+				updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1
+			RETURNING `+suiteColumns,
+			suite.ID, suite.Name, suite.Description, suite.Kind, nullJSON(filter)))
+	}
+	if err != nil {
+		return suite, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM test_suite_cases WHERE suite_id = $1`, suite.ID); err != nil {
+		return suite, err
+	}
+	if suite.Kind == suiteKindStatic && len(suite.TestCaseIDs) > 0 {
+		_, err := tx.Exec(`
+			INSERT INTO test_suite_cases (suite_id, test_case_id, position)
+			SELECT $1, t.id, t.position FROM unnest($2::uuid[]) WITH ORDINALITY AS t(id, position)
+		`, suite.ID, pq.Array(suite.TestCaseIDs))
+		if err != nil {
+			return suite, err
+		}
+		saved.TestCaseIDs = suite.TestCaseIDs
+	}
+
+	return saved, tx.Commit()
+}
+
+func writeSavedSuite(w http.ResponseWriter, suite TestSuite, create bool) {
+	saved, err := saveSuite(suite, create)
+	var verr validationError
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Suite not found", http.StatusNotFound)
+		return
+	case errors.As(err, &verr):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if create {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(saved)
+}
+
+func createSuite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var suite TestSuite
+	if err := json.NewDecoder(r.Body).Decode(&suite); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if suite.ID == uuid.Nil {
+		suite.ID = uuid.New()
+	}
+	suite.ProjectID = projectID
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1)", projectID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	writeSavedSuite(w, suite, true)
+}
+
+func listSuites(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT `+suiteColumns+` FROM test_suites WHERE project_id = $1 ORDER BY name`, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	suites := []TestSuite{}
+	index := make(map[uuid.UUID]int)
+	for rows.Next() {
+		suite, err := scanSuite(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		index[suite.ID] = len(suites)
+		suites = append(suites, suite)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	members, err := db.Query(`
+		SELECT m.suite_id, m.test_case_id FROM test_suite_cases m
+		JOIN test_suites s ON s.id = m.suite_id
+		WHERE s.project_id = $1
+		ORDER BY m.suite_id, m.position
+	`, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer members.Close()
+	for members.Next() {
+		var suiteID, tcID uuid.UUID
+		if err := members.Scan(&suiteID, &tcID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if i, ok := index[suiteID]; ok {
+			suites[i].TestCaseIDs = append(suites[i].TestCaseIDs, tcID)
+		}
+	}
+	if err := members.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suites)
+}
+
+func getSuite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid suite ID", http.StatusBadRequest)
+		return
+	}
+
+	suite, err := loadSuite(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Suite not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suite)
+}
+
+func updateSuite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid suite ID", http.StatusBadRequest)
+		return
+	}
+
+	var suite TestSuite
+	if err := json.NewDecoder(r.Body).Decode(&suite); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := loadSuite(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Suite not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	suite.ID = id
+	suite.ProjectID = current.ProjectID
+	writeSavedSuite(w, suite, false)
+}
+
+func deleteSuite(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid suite ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM test_suites WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Suite not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listSuiteTestCases shows what running the suite right now would execute.
+func listSuiteTestCases(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid suite ID", http.StatusBadRequest)
+		return
+	}
+
+	suite, err := loadSuite(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Suite not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ids, err := resolveSuite(suite)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+testCaseColumns+` FROM `+testCaseFrom+`
+		WHERE tc.id = ANY($1)
+		ORDER BY array_position($1, tc.id)
+	`, pq.Array(ids))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	cases := []TestCase{}
+	for rows.Next() {
+		tc, err := scanTestCase(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cases = append(cases, tc)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := loadTestCaseLinks(cases); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cases)
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"zis/internal/executor"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultTestCasePageSize = 50
+	maxTestCasePageSize     = 500
+
+	// lastStatusNotRun filters for test cases without any result.
+	lastStatusNotRun = "not_run"
+
+	cursorTimeLayout = "2006-01-02 15:04:05.999999"
+)
+
+const testCaseColumns = `tc.id, tc.name, COALESCE(tc.description, ''), tc.json_data, tc.entity_id, tc.project_id,
+	tc.version, tc.created_at, tc.updated_at, lr.status`
+
+// testCaseFrom joins the latest result of every case so that it can be both
+// returned and filtered on. Deleted cases are left out.
+const testCaseFrom = `(SELECT * FROM test_cases WHERE deleted_at IS NULL) tc
+	LEFT JOIN LATERAL (
+		SELECT status FROM test_run_results WHERE test_case_id = tc.id ORDER BY run_time DESC LIMIT 1
+	) lr ON true`
+
+type TestCasePage struct {
+	TestCases  []TestCase `json:"test_cases"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// testCasePatch holds the fields of a PATCH body; absent fields stay as
+// they are.
+type testCasePatch struct {
+	Name           *string          `json:"name"`
+	Description    *string          `json:"description"`
+	JSONData       *json.RawMessage `json:"json_data"`
+	EntityID       *uuid.UUID       `json:"entity_id"`
+	RequirementIDs *[]uuid.UUID     `json:"requirement_ids"`
+	DependsOn      *[]uuid.UUID     `json:"depends_on"`
+	Steps          *[]TestStep      `json:"steps"`
+}
+
+func scanTestCase(row interface{ Scan(...interface{}) error }) (TestCase, error) {
+	var tc TestCase
+	var jsonData []byte
+	var createdAt, updatedAt sql.NullTime
+	var lastStatus sql.NullString
+	err := row.Scan(&tc.ID, &tc.Name, &tc.Description, &jsonData, &tc.EntityID, &tc.ProjectID,
+		&tc.Version, &createdAt, &updatedAt, &lastStatus)
+	if len(jsonData) > 0 {
+		tc.JSONData = jsonData
+	}
+	if createdAt.Valid {
+		tc.CreatedAt = &createdAt.Time
+	}
+	if updatedAt.Valid {
+		tc.UpdatedAt = &updatedAt.Time
+	}
+	tc.LastStatus = lastStatus.String
+	return tc, err
+}
+
+// loadTestCaseLinks fills in the dependencies, requirement links and
+// expanded steps of the given cases.
+func loadTestCaseLinks(cases []TestCase) error {
+	if len(cases) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(cases))
+	index := make(map[uuid.UUID]int, len(cases))
+	for i, tc := range cases {
+		ids[i] = tc.ID
+		index[tc.ID] = i
+	}
+
+	deps, err := db.Query(`SELECT test_case_id, depends_on_id FROM test_case_dependencies WHERE test_case_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer deps.Close()
+
+	for deps.Next() {
+		var tcID, depID uuid.UUID
+		if err := deps.Scan(&tcID, &depID); err != nil {
+			return err
+		}
+		if i, ok := index[tcID]; ok {
+			cases[i].DependsOn = append(cases[i].DependsOn, depID)
+		}
+	}
+	if err := deps.Err(); err != nil {
+		return err
+	}
+
+	links, err := db.Query(`SELECT test_case_id, requirement_id FROM test_case_requirements WHERE test_case_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer links.Close()
+
+	for links.Next() {
+		var tcID, reqID uuid.UUID
+		if err := links.Scan(&tcID, &reqID); err != nil {
+			return err
+		}
+		if i, ok := index[tcID]; ok {
+			cases[i].RequirementIDs = append(cases[i].RequirementIDs, reqID)
+		}
+	}
+	if err := links.Err(); err != nil {
+		return err
+	}
+
+	steps, err := loadTestCaseSteps(ids)
+	if err != nil {
+		return err
+	}
+	for i := range cases {
+		cases[i].Steps = steps[cases[i].ID]
+	}
+	return nil
+}
+
+func loadTestCase(id uuid.UUID) (TestCase, error) {
+	tc, err := scanTestCase(db.QueryRow(`SELECT `+testCaseColumns+` FROM `+testCaseFrom+` WHERE tc.id = $1`, id))
+	if err != nil {
+		return tc, err
+	}
+	cases := []TestCase{tc}
+	err = loadTestCaseLinks(cases)
+	return cases[0], err
+}
+
+// Cursors point just past the last case of a page in (created_at, id)
+// order. They are opaque to clients.
+func encodeTestCaseCursor(tc TestCase) string {
+	var createdAt time.Time
+	if tc.CreatedAt != nil {
+		createdAt = *tc.CreatedAt
+	}
+	raw := createdAt.Format(cursorTimeLayout) + "|" + tc.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeTestCaseCursor(cursor string) (string, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", uuid.Nil, validationError("Invalid cursor")
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return "", uuid.Nil, validationError("Invalid cursor")
+	}
+	if _, err := time.Parse(cursorTimeLayout, createdAt); err != nil {
+		return "", uuid.Nil, validationError("Invalid cursor")
+	}
+	tcID, err := uuid.Parse(id)
+	if err != nil {
+		return "", uuid.Nil, validationError("Invalid cursor")
+	}
+	return createdAt, tcID, nil
+}
+
+// TestCaseFilter selects test cases by their fields, their latest result
+// and their json_data. Tags are matched against the json_data "tags" array.
+type TestCaseFilter struct {
+	ProjectID     *uuid.UUID      `json:"project_id,omitempty"`
+	EntityID      *uuid.UUID      `json:"entity_id,omitempty"`
+	RequirementID *uuid.UUID      `json:"requirement_id,omitempty"`
+	LastStatus    string          `json:"last_status,omitempty"`
+	Query         string          `json:"q,omitempty"`
+	Contains      json.RawMessage `json:"contains,omitempty"`
+	Tags          []string        `json:"tags,omitempty"`
+}
+
+// testCaseFilterWhere uses the arguments $1 to $7 from TestCaseFilter.args.
+const testCaseFilterWhere = `($1::uuid IS NULL OR tc.project_id = $1)
+	AND ($2::uuid IS NULL OR tc.entity_id = $2)
+	AND ($3::uuid IS NULL OR EXISTS (
+		SELECT 1 FROM test_case_requirements l WHERE l.test_case_id = tc.id AND l.requirement_id = $3
+	))
+	AND ($4::text IS NULL OR lr.status = $4 OR ($4 = $5 AND lr.status IS NULL))
+	AND ($6::text IS NULL OR tc.name ILIKE $6 OR tc.description ILIKE $6)
+	AND ($7::jsonb IS NULL OR tc.json_data @> $7::jsonb)`
+
+func (f TestCaseFilter) args() ([]interface{}, error) {
+	var lastStatus, search *string
+	if f.LastStatus != "" {
+		lastStatus = &f.LastStatus
+	}
+	if f.Query != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Query) + "%"
+		search = &pattern
+	}
+
+	var contains map[string]interface{}
+	if len(f.Contains) > 0 && string(f.Contains) != "null" {
+		if err := json.Unmarshal(f.Contains, &contains); err != nil {
+			return nil, validationError("contains must be a JSON object")
+		}
+	}
+	if len(f.Tags) > 0 {
+		if contains == nil {
+			contains = map[string]interface{}{}
+		}
+		contains["tags"] = f.Tags
+	}
+	var filter json.RawMessage
+	if contains != nil {
+		var err error
+		if filter, err = json.Marshal(contains); err != nil {
+			return nil, err
+		}
+	}
+
+	return []interface{}{f.ProjectID, f.EntityID, f.RequirementID, lastStatus, lastStatusNotRun, search,
+		nullJSON(filter)}, nil
+}
+
+func optionalUUID(s, name string) (*uuid.UUID, error) {
+	if s == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return nil, validationError("Invalid " + name)
+	}
+	return &id, nil
+}
+
+func listTestCases(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	q := r.URL.Query()
+
+	var f TestCaseFilter
+	var err error
+	if f.ProjectID, err = optionalUUID(q.Get("project_id"), "project ID"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.EntityID, err = optionalUUID(q.Get("entity_id"), "entity ID"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.RequirementID, err = optionalUUID(q.Get("requirement_id"), "requirement ID"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Contains, err = containmentFilter(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.LastStatus = q.Get("last_status")
+	f.Query = q.Get("q")
+	f.Tags = q["tag"]
+
+	args, err := f.args()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultTestCasePageSize
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxTestCasePageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxTestCasePageSize), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var afterTime *string
+	var afterID uuid.NullUUID
+	if v := q.Get("cursor"); v != "" {
+		createdAt, id, err := decodeTestCaseCursor(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		afterTime = &createdAt
+		afterID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	// One extra row tells whether there is a next page.
+	args = append(args, afterTime, afterID, limit+1)
+	rows, err := db.Query(`
+		SELECT `+testCaseColumns+` FROM `+testCaseFrom+`
+		WHERE `+testCaseFilterWhere+`
+			AND ($8::timestamp IS NULL OR (tc.created_at, tc.id) > ($8::timestamp, $9::uuid))
+		ORDER BY tc.created_at, tc.id
+		LIMIT $10
+	`, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	page := TestCasePage{TestCases: []TestCase{}}
+	for rows.Next() {
+		tc, err := scanTestCase(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.TestCases = append(page.TestCases, tc)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(page.TestCases) > limit {
+		page.TestCases = page.TestCases[:limit]
+		page.NextCursor = encodeTestCaseCursor(page.TestCases[limit-1])
+	}
+	if err := loadTestCaseLinks(page.TestCases); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func getTestCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	tc, err := loadTestCase(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Test case not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tc)
+}
+
+func updateTestCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	var tc TestCase
+	if err := json.NewDecoder(r.Body).Decode(&tc); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := loadTestCase(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Test case not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tc.ID = id
+	tc.ProjectID = current.ProjectID
+	writeSavedTestCase(w, tc)
+}
+
+func patchTestCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	var patch testCasePatch
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tc, err := loadTestCase(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Test case not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if patch.Name != nil {
+		tc.Name = *patch.Name
+	}
+	if patch.Description != nil {
+		tc.Description = *patch.Description
+	}
+	if patch.JSONData != nil {
+		tc.JSONData = *patch.JSONData
+	}
+	if patch.EntityID != nil {
+		tc.EntityID = *patch.EntityID
+	}
+	if patch.RequirementIDs != nil {
+		tc.RequirementIDs = *patch.RequirementIDs
+	}
+	if patch.DependsOn != nil {
+		tc.DependsOn = *patch.DependsOn
+	}
+	if patch.Steps != nil {
+		tc.Steps = *patch.Steps
+	}
+	writeSavedTestCase(w, tc)
+}
+
+// saveTestCase updates a case together with its dependencies, requirement
+// links and steps, and stores the result as a new version. The case keeps
+// its project.
+func saveTestCase(tc TestCase) error {
+	if tc.Name == "" {
+		return validationError("Test case name is required")
+	}
+	if err := executor.ValidatePolicy(tc.JSONData); err != nil {
+		return validationError(err.Error())
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var inProject bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM entities WHERE id = $1 AND project_id = $2 AND deleted_at IS NULL)`,
+		tc.EntityID, tc.ProjectID).Scan(&inProject)
+	if err != nil {
+		return err
+	}
+	if !inProject {
+		return validationError("Entity not found in the test case's project")
+	}
+
+	cases := []TestCase{tc}
+	if err := validateDependencies(tx, cases); err != nil {
+		return err
+	}
+	if err := validateRequirementLinks(tx, cases); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+		UPDATE test_cases SET name = $2, description = $3, json_data = $4, entity_id = $5,
+			version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND deleted_at IS NULL
+	`, tc.ID, tc.Name, tc.Description, nullJSON(tc.JSONData), tc.EntityID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := saveDependencies(tx, tc); err != nil {
+		return err
+	}
+	if err := saveRequirementLinks(tx, tc); err != nil {
+		return err
+	}
+	if err := saveSteps(tx, tc); err != nil {
+		return err
+	}
+	if err := snapshotTestCase(tx, tc.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func writeSavedTestCase(w http.ResponseWriter, tc TestCase) {
+	err := saveTestCase(tc)
+	var verr validationError
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Test case not found", http.StatusNotFound)
+		return
+	case errors.As(err, &verr):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := loadTestCase(tc.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func deleteTestCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	err = softDeleteTestCase(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Test case not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// softDeleteTestCase marks the case deleted so that its run results,
+// versions and manual assignments stay intact. It stops taking part in
+// dependencies, suites and requirement coverage right away.
+func softDeleteTestCase(id uuid.UUID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE test_cases SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM test_case_dependencies WHERE test_case_id = $1`,
+		`DELETE FROM test_suite_cases WHERE test_case_id = $1`,
+		`DELETE FROM test_case_requirements WHERE test_case_id = $1`,
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return err
+		}
+	}
+
+	// Cases that depended on this one lose that dependency.
+	dependents, err := execReturningIDs(tx, `DELETE FROM test_case_dependencies WHERE depends_on_id = $1 RETURNING test_case_id`, id)
+	if err != nil {
+		return err
+	}
+	if err := bumpLinkedVersions(tx, dependents); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
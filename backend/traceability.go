@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"zis/internal/executor"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+const (
+	coverageUncovered      = "uncovered"
+	coverageCoveredNotRun  = "covered_not_run"
+	coverageCoveredFailing = "covered_failing"
+	coverageCoveredPassing = "covered_passing"
+)
+
+type TraceabilityTestCase struct {
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	LastStatus  string     `json:"last_status,omitempty"`
+	LastRunID   *uuid.UUID `json:"last_run_id,omitempty"`
+	LastRunTime *time.Time `json:"last_run_time,omitempty"`
+}
+
+type TraceabilityRow struct {
+	Requirement Requirement            `json:"requirement"`
+	Coverage    string                 `json:"coverage"`
+	TestCases   []TraceabilityTestCase `json:"test_cases"`
+}
+
+type CoverageSummary struct {
+	Total          int `json:"total"`
+	Uncovered      int `json:"uncovered"`
+	CoveredNotRun  int `json:"covered_not_run"`
+	CoveredFailing int `json:"covered_failing"`
+	CoveredPassing int `json:"covered_passing"`
+}
+
+type TraceabilityReport struct {
+	ProjectID    uuid.UUID         `json:"project_id"`
+	GeneratedAt  time.Time         `json:"generated_at"`
+	Coverage     CoverageSummary   `json:"coverage"`
+	Requirements []TraceabilityRow `json:"requirements"`
+}
+
+var conclusiveStatuses = []string{executor.StatusPassed, executor.StatusFailed, executor.StatusError}
+
+// coverageOf classifies a requirement by the latest results of its test
+// cases: any failure wins, and it only counts as passing once every linked
+// case has passed. Only conclusive results (passed, failed, error) are
+// considered; a skipped, blocked or cancelled execution says nothing about
+// the requirement, so a case keeps its previous verdict and a case without
+// any conclusive result counts as not run.
+func coverageOf(cases []TraceabilityTestCase) string {
+	if len(cases) == 0 {
+		return coverageUncovered
+	}
+	passed := 0
+	for _, tc := range cases {
+		switch tc.LastStatus {
+		case executor.StatusFailed, executor.StatusError:
+			return coverageCoveredFailing
+		case executor.StatusPassed:
+			passed++
+		}
+	}
+	if passed == len(cases) {
+		return coverageCoveredPassing
+	}
+	return coverageCoveredNotRun
+}
+
+func buildTraceability(projectID uuid.UUID) (*TraceabilityReport, error) {
+	reqs, err := queryRequirements(`
+		SELECT `+requirementColumns+` FROM requirements
+		WHERE project_id = $1
+		ORDER BY key
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT l.requirement_id, tc.id, tc.name, lr.status, lr.run_id, lr.run_time
+		FROM test_case_requirements l
+		JOIN requirements r ON r.id = l.requirement_id
+		JOIN test_cases tc ON tc.id = l.test_case_id
+		LEFT JOIN LATERAL (
+			SELECT status, run_id, run_time FROM test_run_results
+			WHERE test_case_id = tc.id AND status = ANY($2)
+			ORDER BY run_time DESC LIMIT 1
+		) lr ON true
+		WHERE r.project_id = $1
+		ORDER BY tc.name
+	`, projectID, pq.Array(conclusiveStatuses))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	linked := make(map[uuid.UUID][]TraceabilityTestCase)
+	for rows.Next() {
+		var reqID uuid.UUID
+		var tc TraceabilityTestCase
+		var status *string
+		var runID uuid.NullUUID
+		var runTime *time.Time
+		if err := rows.Scan(&reqID, &tc.ID, &tc.Name, &status, &runID, &runTime); err != nil {
+			return nil, err
+		}
+		if status != nil {
+			tc.LastStatus = *status
+		}
+		if runID.Valid {
+			tc.LastRunID = &runID.UUID
+		}
+		tc.LastRunTime = runTime
+		linked[reqID] = append(linked[reqID], tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &TraceabilityReport{
+		ProjectID:    projectID,
+		GeneratedAt:  time.Now().UTC(),
+		Requirements: make([]TraceabilityRow, 0, len(reqs)),
+	}
+	for _, req := range reqs {
+		row := TraceabilityRow{Requirement: req, TestCases: linked[req.ID]}
+		if row.TestCases == nil {
+			row.TestCases = []TraceabilityTestCase{}
+		}
+		row.Coverage = coverageOf(row.TestCases)
+
+		report.Coverage.Total++
+		switch row.Coverage {
+		case coverageUncovered:
+			report.Coverage.Uncovered++
+		case coverageCoveredNotRun:
+			report.Coverage.CoveredNotRun++
+		case coverageCoveredFailing:
+			report.Coverage.CoveredFailing++
+		case coverageCoveredPassing:
+			report.Coverage.CoveredPassing++
+		}
+		report.Requirements = append(report.Requirements, row)
+	}
+	return report, nil
+}
+
+// writeTraceabilityCSV flattens the report to one line per requirement and
+// test case; uncovered requirements get a single line with empty case columns.
+func writeTraceabilityCSV(w http.ResponseWriter, report *TraceabilityReport) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="traceability.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"requirement_key", "requirement_title", "requirement_status", "coverage",
+		"test_case_id", "test_case_name", "last_status", "last_run_id", "last_run_time"})
+	for _, row := range report.Requirements {
+		req := row.Requirement
+		if len(row.TestCases) == 0 {
+			out.Write([]string{req.Key, req.Title, req.Status, row.Coverage, "", "", "", "", ""})
+			continue
+		}
+		for _, tc := range row.TestCases {
+			var runID, runTime string
+			if tc.LastRunID != nil {
+				runID = tc.LastRunID.String()
+			}
+			if tc.LastRunTime != nil {
+				runTime = tc.LastRunTime.Format(time.RFC3339)
+			}
+			out.Write([]string{req.Key, req.Title, req.Status, row.Coverage,
+				tc.ID.String(), tc.Name, tc.LastStatus, runID, runTime})
+		}
+	}
+	out.Flush()
+}
+
+func getTraceability(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" && r.Header.Get("Accept") == "text/csv" {
+		format = "csv"
+	}
+	if format != "" && format != "csv" && format != "json" {
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1)", projectID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	report, err := buildTraceability(projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == "csv" {
+		writeTraceabilityCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+// TestCaseVersion is an immutable revision of a test case. A new one is
+// stored every time the case, its links or its steps change.
+type TestCaseVersion struct {
+	TestCaseID     uuid.UUID       `json:"test_case_id"`
+	Version        int             `json:"version"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	JSONData       json.RawMessage `json:"json_data"`
+	EntityID       uuid.UUID       `json:"entity_id"`
+	DependsOn      []uuid.UUID     `json:"depends_on"`
+	RequirementIDs []uuid.UUID     `json:"requirement_ids"`
+	Steps          []TestStep      `json:"steps"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+// FieldChange is one field that differs between two versions. Fields of
+// json_data are compared key by key and steps position by position.
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type TestCaseDiff struct {
+	TestCaseID uuid.UUID     `json:"test_case_id"`
+	From       int           `json:"from"`
+	To         int           `json:"to"`
+	Changes    []FieldChange `json:"changes"`
+}
+
+const versionColumns = `test_case_id, version, name, COALESCE(description, ''), json_data, entity_id,
+	depends_on, requirement_ids, steps, created_at`
+
+func scanVersion(row interface{ Scan(...interface{}) error }) (TestCaseVersion, error) {
+	var v TestCaseVersion
+	var jsonData, steps []byte
+	var deps, reqs []string
+	err := row.Scan(&v.TestCaseID, &v.Version, &v.Name, &v.Description, &jsonData, &v.EntityID,
+		pq.Array(&deps), pq.Array(&reqs), &steps, &v.CreatedAt)
+	if err != nil {
+		return v, err
+	}
+	if len(jsonData) > 0 {
+		v.JSONData = jsonData
+	}
+	v.DependsOn = parseUUIDs(deps)
+	v.RequirementIDs = parseUUIDs(reqs)
+	v.Steps = []TestStep{}
+	if err := json.Unmarshal(steps, &v.Steps); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+func parseUUIDs(values []string) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(values))
+	for _, s := range values {
+		if id, err := uuid.Parse(s); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// snapshotTestCase stores the current state of a case as its current
+// version. Shared steps are stored expanded so that the revision keeps
+// saying what was executed even after the shared step changes.
+func snapshotTestCase(tx *sql.Tx, id uuid.UUID) error {
+	_, err := tx.Exec(`
+		INSERT INTO test_case_versions (test_case_id, version, name, description, json_data, entity_id,
+			depends_on, requirement_ids, steps)
+		SELECT tc.id, tc.version, tc.name, tc.description, tc.json_data, tc.entity_id,
+			ARRAY(SELECT depends_on_id FROM test_case_dependencies WHERE test_case_id = tc.id ORDER BY depends_on_id),
+			ARRAY(SELECT requirement_id FROM test_case_requirements WHERE test_case_id = tc.id ORDER BY requirement_id),
+			COALESCE((
+				SELECT jsonb_agg(jsonb_strip_nulls(jsonb_build_object(
+					'id', s.id,
+					'position', s.position,
+					'action', CASE WHEN s.shared_step_id IS NULL THEN s.action ELSE ss.action END,
+					'test_data', CASE WHEN s.shared_step_id IS NULL THEN s.test_data ELSE ss.test_data END,
+					'expected_result', CASE WHEN s.shared_step_id IS NULL THEN s.expected_result ELSE ss.expected_result END,
+					'shared_step_id', s.shared_step_id
+				)) ORDER BY s.position)
+				FROM test_case_steps s LEFT JOIN shared_steps ss ON ss.id = s.shared_step_id
+				WHERE s.test_case_id = tc.id
+			), '[]')
+		FROM test_cases tc WHERE tc.id = $1
+	`, id)
+	return err
+}
+
+// bumpLinkedVersions gives every live case among ids a new version after its
+// links were changed from the other side, so that the latest version still
+// describes the case. Cases are locked in id order to avoid deadlocks.
+func bumpLinkedVersions(tx *sql.Tx, ids []uuid.UUID) error {
+	ids = uniqueIDs(ids)
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	for _, id := range ids {
+		res, err := tx.Exec(`
+			UPDATE test_cases SET version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND deleted_at IS NULL
+		`, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		if err := snapshotTestCase(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execReturningIDs runs a statement that returns one case id per row.
+func execReturningIDs(tx *sql.Tx, query string, args ...interface{}) ([]uuid.UUID, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func loadVersion(tcID uuid.UUID, version int) (TestCaseVersion, error) {
+	return scanVersion(db.QueryRow(`SELECT `+versionColumns+` FROM test_case_versions WHERE test_case_id = $1 AND version = $2`,
+		tcID, version))
+}
+
+func listTestCaseVersions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tcID, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`SELECT `+versionColumns+` FROM test_case_versions WHERE test_case_id = $1 ORDER BY version DESC`, tcID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	versions := []TestCaseVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, "Test case not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+func getTestCaseVersion(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tcID, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+	version, err := strconv.Atoi(ps.ByName("version"))
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+
+	v, err := loadVersion(tcID, version)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// diffTestCase compares two versions of a case, given as ?from=&to=. "to"
+// defaults to the latest version and "from" to the one before it.
+func diffTestCase(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tcID, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid test case ID", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	to := 0
+	if s := q.Get("to"); s != "" {
+		if to, err = strconv.Atoi(s); err != nil || to < 1 {
+			http.Error(w, "Invalid to version", http.StatusBadRequest)
+			return
+		}
+	} else {
+		err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM test_case_versions WHERE test_case_id = $1`, tcID).Scan(&to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	from := to - 1
+	if s := q.Get("from"); s != "" {
+		if from, err = strconv.Atoi(s); err != nil || from < 1 {
+			http.Error(w, "Invalid from version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	older, err := loadVersion(tcID, from)
+	var newer TestCaseVersion
+	if err == nil {
+		newer, err = loadVersion(tcID, to)
+	}
+	if err == sql.ErrNoRows {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TestCaseDiff{TestCaseID: tcID, From: from, To: to, Changes: diffVersions(older, newer)})
+}
+
+func diffVersions(a, b TestCaseVersion) []FieldChange {
+	changes := []FieldChange{}
+	add := func(field string, from, to interface{}) {
+		fromJSON, _ := json.Marshal(from)
+		toJSON, _ := json.Marshal(to)
+		if !bytes.Equal(fromJSON, toJSON) {
+			changes = append(changes, FieldChange{Field: field, From: from, To: to})
+		}
+	}
+
+	add("name", a.Name, b.Name)
+	add("description", a.Description, b.Description)
+	add("entity_id", a.EntityID, b.EntityID)
+
+	var aData, bData map[string]interface{}
+	aIsObject := len(a.JSONData) == 0 || json.Unmarshal(a.JSONData, &aData) == nil
+	bIsObject := len(b.JSONData) == 0 || json.Unmarshal(b.JSONData, &bData) == nil
+	if aIsObject && bIsObject {
+		keys := map[string]bool{}
+		for k := range aData {
+			keys[k] = true
+		}
+		for k := range bData {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			add("json_data."+k, aData[k], bData[k])
+		}
+	} else {
+		add("json_data", a.JSONData, b.JSONData)
+	}
+
+	add("depends_on", a.DependsOn, b.DependsOn)
+	add("requirement_ids", a.RequirementIDs, b.RequirementIDs)
+
+	for i := 0; i < len(a.Steps) || i < len(b.Steps); i++ {
+		var from, to interface{}
+		if i < len(a.Steps) {
+			from = stepContent(a.Steps[i])
+		}
+		if i < len(b.Steps) {
+			to = stepContent(b.Steps[i])
+		}
+		add("steps."+strconv.Itoa(i+1), from, to)
+	}
+	return changes
+}
+
+// stepContent leaves out the step ID and position so that recreated but
+// identical steps do not show up as changes.
+func stepContent(s TestStep) interface{} {
+	return struct {
+		Action         string     `json:"action"`
+		TestData       string     `json:"test_data,omitempty"`
+		ExpectedResult string     `json:"expected_result,omitempty"`
+		SharedStepID   *uuid.UUID `json:"shared_step_id,omitempty"`
+	}{s.Action, s.TestData, s.ExpectedResult, s.SharedStepID}
+}
+
+// restoreTestCase makes an old version the content of the case again. The
+// restore is itself stored as a new version, so history is never rewritten.
+// Links to cases, requirements or shared steps that no longer exist are
+// dropped; such shared steps are kept inline as they read at the time.
+func restoreTestCase(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req struct {
+		TestCaseID uuid.UUID `json:"test_case_id"`
+		Version    int       `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	v, err := loadVersion(req.TestCaseID, req.Version)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tc, err := loadTestCase(req.TestCaseID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Test case not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tc.Name, tc.Description, tc.JSONData, tc.EntityID = v.Name, v.Description, v.JSONData, v.EntityID
+	tc.Steps = v.Steps
+	if err := restoreLinks(&tc, v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeSavedTestCase(w, tc)
+}
+
+func restoreLinks(tc *TestCase, v TestCaseVersion) error {
+	existing := func(query string, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+		rows, err := db.Query(query, pq.Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		found := map[uuid.UUID]bool{}
+		for rows.Next() {
+			var id uuid.UUID
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			found[id] = true
+		}
+		return found, rows.Err()
+	}
+
+	cases, err := existing(`SELECT id FROM test_cases WHERE id = ANY($1) AND deleted_at IS NULL`, v.DependsOn)
+	if err != nil {
+		return err
+	}
+	tc.DependsOn = nil
+	for _, id := range v.DependsOn {
+		if cases[id] {
+			tc.DependsOn = append(tc.DependsOn, id)
+		}
+	}
+
+	reqs, err := existing(`SELECT id FROM requirements WHERE id = ANY($1)`, v.RequirementIDs)
+	if err != nil {
+		return err
+	}
+	tc.RequirementIDs = nil
+	for _, id := range v.RequirementIDs {
+		if reqs[id] {
+			tc.RequirementIDs = append(tc.RequirementIDs, id)
+		}
+	}
+
+	var sharedIDs []uuid.UUID
+	for _, s := range tc.Steps {
+		if s.SharedStepID != nil {
+			sharedIDs = append(sharedIDs, *s.SharedStepID)
+		}
+	}
+	shared, err := existing(`SELECT id FROM shared_steps WHERE id = ANY($1)`, sharedIDs)
+	if err != nil {
+		return err
+	}
+	for i, s := range tc.Steps {
+		if s.SharedStepID != nil && !shared[*s.SharedStepID] {
+			tc.Steps[i].SharedStepID = nil
+		}
+	}
+	return nil
+}
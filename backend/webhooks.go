@@ -0,0 +1,224 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"zis/internal/webhook"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+)
+
+type WebhookSubscription struct {
+	ID         uuid.UUID `json:"id"`
+	ProjectID  uuid.UUID `json:"project_id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	Secret     string    `json:"secret,omitempty"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type caseStatusChangedData struct {
+	RunID          uuid.UUID   `json:"run_id"`
+	TestCaseID     uuid.UUID   `json:"test_case_id"`
+	TestCaseName   string      `json:"test_case_name"`
+	RequirementIDs []uuid.UUID `json:"requirement_ids,omitempty"`
+	PreviousStatus string      `json:"previous_status,omitempty"`
+	Status         string      `json:"status"`
+	Message        string      `json:"message,omitempty"`
+}
+
+type runFinishedData struct {
+	RunID         uuid.UUID  `json:"run_id"`
+	Status        string     `json:"status"`
+	EnvironmentID *uuid.UUID `json:"environment_id,omitempty"`
+	Total         int        `json:"total"`
+	Passed        int        `json:"passed"`
+	Failed        int        `json:"failed"`
+	Errors        int        `json:"errors"`
+	Skipped       int        `json:"skipped"`
+	Blocked       int        `json:"blocked"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+func notifyRunFinished(tx *sql.Tx, run *TestRun, projectIDs []uuid.UUID) error {
+	data := runFinishedData{
+		RunID:         run.ID,
+		Status:        run.Status,
+		EnvironmentID: run.EnvironmentID,
+		Total:         run.Total,
+		Passed:        run.Passed,
+		Failed:        run.Failed,
+		Errors:        run.Errors,
+		Skipped:       run.Skipped,
+		Blocked:       run.Blocked,
+		StartedAt:     run.StartedAt,
+		FinishedAt:    run.FinishedAt,
+	}
+	for _, projectID := range projectIDs {
+		if err := enqueueEvent(tx, webhook.NewEvent(webhook.EventRunFinished, projectID, data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueEvent writes one outbox row per subscription interested in the
+// event. It runs in the caller's transaction, so the event exists if and
+// only if the change that caused it was committed.
+func enqueueEvent(tx *sql.Tx, event webhook.Event) error {
+	rows, err := tx.Query(`
+		SELECT id, event_types FROM webhook_subscriptions
+		WHERE project_id = $1 AND active
+	`, event.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	var subs []uuid.UUID
+	for rows.Next() {
+		var sub webhook.Subscription
+		if err := rows.Scan(&sub.ID, pq.Array(&sub.EventTypes)); err != nil {
+			rows.Close()
+			return err
+		}
+		if sub.Wants(event.Type) {
+			subs = append(subs, sub.ID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+
+	payload, err := webhook.Payload(event)
+	if err != nil {
+		return err
+	}
+	for _, subID := range subs {
+		_, err := tx.Exec(`
+			INSERT INTO outbox (id, event_id, event_type, subscription_id, payload)
+			VALUES ($1, $2, $3, $4, $5)
+		`, uuid.New(), event.ID, event.Type, subID, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	var sub WebhookSubscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sub.URL == "" {
+		http.Error(w, "Webhook URL is required", http.StatusBadRequest)
+		return
+	}
+	if err := webhook.ValidateURL(sub.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sub.ID == uuid.Nil {
+		sub.ID = uuid.New()
+	}
+	if sub.EventTypes == nil {
+		sub.EventTypes = []string{}
+	}
+	sub.ProjectID = projectID
+	sub.Active = true
+
+	var exists bool
+	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1)", projectID).Scan(&exists)
+	if err != nil || !exists {
+		http.Error(w, "Project not found", http.StatusNotFound)
+		return
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO webhook_subscriptions (id, project_id, url, event_types, secret)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_at
+	`, sub.ID, sub.ProjectID, sub.URL, pq.Array(sub.EventTypes), sub.Secret).Scan(&sub.CreatedAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sub.Secret = ""
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sub)
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	projectID, err := uuid.Parse(ps.ByName("projectId"))
+	if err != nil {
+		http.Error(w, "Invalid project ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, project_id, url, event_types, active, created_at FROM webhook_subscriptions
+		WHERE project_id = $1 ORDER BY created_at
+	`, projectID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	subs := []WebhookSubscription{}
+	for rows.Next() {
+		var sub WebhookSubscription
+		if err := rows.Scan(&sub.ID, &sub.ProjectID, &sub.URL, pq.Array(&sub.EventTypes), &sub.Active, &sub.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(subs)
+}
+
+func deleteWebhook(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := uuid.Parse(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec(`DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
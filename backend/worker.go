@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"zis/internal/config"
+	"zis/internal/executor"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+type runWorkerPool struct {
+	wake         chan struct{}
+	wg           sync.WaitGroup
+	pollInterval time.Duration
+	lease        time.Duration
+	policy       executor.Policy
+	parallelism  int
+
+	mu      sync.Mutex
+	running map[uuid.UUID]context.CancelFunc
+}
+
+func startRunWorkers(ctx context.Context) *runWorkerPool {
+	cfg := config.GetConfig()
+
+	workers := cfg.Runner.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	p := &runWorkerPool{
+		wake:         make(chan struct{}, workers),
+		pollInterval: cfg.Runner.PollInterval,
+		lease:        5 * cfg.Runner.PollInterval,
+		parallelism:  cfg.Runner.Parallelism,
+		policy: executor.Policy{
+			Timeout:     cfg.Runner.CaseTimeout,
+			MaxAttempts: cfg.Runner.MaxAttempts,
+			Backoff:     cfg.Runner.RetryBackoff,
+		},
+		running: make(map[uuid.UUID]context.CancelFunc),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go p.loop(ctx)
+	}
+
+	log.Printf("Started %d run workers", workers)
+	return p
+}
+
+// notify wakes an idle worker so a freshly queued run does not wait for the
+// next poll. Runs queued on other replicas are picked up by polling.
+func (p *runWorkerPool) notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *runWorkerPool) wait() {
+	p.wg.Wait()
+}
+
+// cancel stops a run executed by this replica. Runs owned by other replicas
+// are stopped by their own watchRun once they see cancel_requested.
+func (p *runWorkerPool) cancel(runID uuid.UUID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cancel, ok := p.running[runID]; ok {
+		cancel()
+	}
+}
+
+func (p *runWorkerPool) loop(ctx context.Context) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			run, err := claimRun(p.lease)
+			if err != nil {
+				log.Printf("Failed to claim run: %v", err)
+				break
+			}
+			if run == nil {
+				break
+			}
+			// A claimed run is finished even during shutdown, otherwise it
+			// would stay in the running state forever.
+			p.execute(context.WithoutCancel(ctx), run)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func loadRunCases(ids []uuid.UUID) ([]TestCase, error) {
+	rows, err := db.Query(`
+		SELECT id, name, entity_id, project_id, json_data, version FROM test_cases
+		WHERE id = ANY($1)
+		ORDER BY array_position($1, id)
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cases []TestCase
+	for rows.Next() {
+		var tc TestCase
+		var jsonData []byte
+		if err := rows.Scan(&tc.ID, &tc.Name, &tc.EntityID, &tc.ProjectID, &jsonData, &tc.Version); err != nil {
+			return nil, err
+		}
+		tc.JSONData = jsonData
+		cases = append(cases, tc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cases, loadTestCaseLinks(cases)
+}
+
+// loadRecordedStatuses returns the status of every case that already has a
+// result in the run.
+func loadRecordedStatuses(runID uuid.UUID) (map[uuid.UUID]string, error) {
+	rows, err := db.Query(`SELECT test_case_id, status FROM test_run_results WHERE run_id = $1`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statuses := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var id uuid.UUID
+		var status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return nil, err
+		}
+		statuses[id] = status
+	}
+	return statuses, rows.Err()
+}
+
+// loadDeletedCases returns which of the cases have been deleted.
+func loadDeletedCases(ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	rows, err := db.Query(`SELECT id FROM test_cases WHERE id = ANY($1) AND deleted_at IS NOT NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		deleted[id] = true
+	}
+	return deleted, rows.Err()
+}
+
+func (p *runWorkerPool) execute(parent context.Context, run *TestRun) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	p.mu.Lock()
+	p.running[run.ID] = cancel
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.running, run.ID)
+		p.mu.Unlock()
+	}()
+
+	go p.watchRun(ctx, run.ID, cancel)
+
+	executeRun(ctx, run, p.policy, p.parallelism)
+}
+
+// watchRun renews the lease on the run and cancels it once a cancellation
+// has been requested.
+func (p *runWorkerPool) watchRun(ctx context.Context, runID uuid.UUID, cancel context.CancelFunc) {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var requested bool
+			err := db.QueryRowContext(ctx, `
+				UPDATE test_runs SET claimed_until = CURRENT_TIMESTAMP + $2::double precision * INTERVAL '1 millisecond'
+				WHERE id = $1
+				RETURNING cancel_requested
+			`, runID, p.lease.Milliseconds()).Scan(&requested)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to renew lease of run %s: %v", runID, err)
+			}
+			if err == nil && requested {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// executeRun runs the cases of a run in dependency order, up to parallelism
+// at a time. Only dependencies between cases of the same run are honoured.
+// When a case does not pass, everything that depends on it is skipped.
+func executeRun(ctx context.Context, run *TestRun, policy executor.Policy, parallelism int) {
+	log.Printf("Executing run %s", run.ID)
+
+	env, err := loadRunEnvironment(run.EnvironmentID)
+	if err != nil {
+		abortRun(run, err)
+		return
+	}
+
+	cases, err := loadRunCases(run.TestCaseIDs)
+	if err == nil {
+		err = setRunTotal(run, len(cases))
+	}
+	if err != nil {
+		abortRun(run, err)
+		return
+	}
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	byID := make(map[uuid.UUID]TestCase, len(cases))
+	for _, tc := range cases {
+		byID[tc.ID] = tc
+	}
+	waiting := make(map[uuid.UUID]int, len(cases))
+	dependents := make(map[uuid.UUID][]uuid.UUID)
+	for _, tc := range cases {
+		for _, dep := range tc.DependsOn {
+			if _, ok := byID[dep]; ok {
+				waiting[tc.ID]++
+				dependents[dep] = append(dependents[dep], tc.ID)
+			}
+		}
+	}
+
+	var ready []TestCase
+	for _, tc := range cases {
+		if waiting[tc.ID] == 0 {
+			ready = append(ready, tc)
+		}
+	}
+
+	resolved := make(map[uuid.UUID]bool, len(cases))
+	var recordErr error
+	var resolve func(tc TestCase, result TestCaseRunResult)
+	release := func(tc TestCase, status string) {
+		for _, id := range dependents[tc.ID] {
+			if status != executor.StatusPassed {
+				resolve(byID[id], skippedResult(id, fmt.Sprintf("dependency %q %s", tc.Name, status)))
+				continue
+			}
+			if waiting[id]--; waiting[id] == 0 && !resolved[id] {
+				ready = append(ready, byID[id])
+			}
+		}
+	}
+	resolve = func(tc TestCase, result TestCaseRunResult) {
+		if resolved[tc.ID] || recordErr != nil {
+			return
+		}
+		resolved[tc.ID] = true
+
+		result.RunID = run.ID
+		if recordErr = recordRunResult(run, tc, result); recordErr != nil {
+			return
+		}
+		release(tc, result.Status)
+	}
+
+	// A run taken over from a replica that died keeps the results it
+	// already recorded; only the remaining cases are executed.
+	recorded, err := loadRecordedStatuses(run.ID)
+	if err != nil {
+		abortRun(run, err)
+		return
+	}
+	for id := range recorded {
+		resolved[id] = true
+	}
+	for _, tc := range cases {
+		if status, ok := recorded[tc.ID]; ok {
+			release(tc, status)
+		}
+	}
+
+	// Cases deleted after the run was queued are not executed.
+	deleted, err := loadDeletedCases(run.TestCaseIDs)
+	if err != nil {
+		abortRun(run, err)
+		return
+	}
+	for _, tc := range cases {
+		if deleted[tc.ID] {
+			resolve(tc, skippedResult(tc.ID, "test case deleted"))
+		}
+	}
+
+	type finished struct {
+		tc     TestCase
+		result TestCaseRunResult
+	}
+	done := make(chan finished, len(cases))
+	inFlight := 0
+
+	for recordErr == nil {
+		for len(ready) > 0 && inFlight < parallelism {
+			tc := ready[0]
+			ready = ready[1:]
+			if resolved[tc.ID] {
+				continue
+			}
+			if ctx.Err() != nil {
+				resolve(tc, skippedResult(tc.ID, "run cancelled"))
+				continue
+			}
+
+			ev := newRunEvent(runEventCaseStarted, run)
+			ev.TestCaseID = &tc.ID
+			ev.Status = ""
+			if err := notifyRunEvent(db, ev); err != nil {
+				log.Printf("Failed to publish start of %s in run %s: %v", tc.ID, run.ID, err)
+			}
+
+			inFlight++
+			go func(tc TestCase) {
+				done <- finished{tc: tc, result: runCase(ctx, tc, policy, env)}
+			}(tc)
+		}
+		if inFlight == 0 {
+			break
+		}
+
+		f := <-done
+		inFlight--
+		resolve(f.tc, f.result)
+	}
+	if recordErr != nil {
+		abortRun(run, recordErr)
+		return
+	}
+
+	// Whatever is left could never become ready, which means the stored
+	// dependencies were changed into a cycle after validation.
+	for _, tc := range cases {
+		resolve(tc, skippedResult(tc.ID, "dependency cycle"))
+	}
+	if recordErr != nil {
+		abortRun(run, recordErr)
+		return
+	}
+
+	status, message := runStatusFinished, ""
+	if ctx.Err() != nil {
+		status, message = runStatusCancelled, "cancelled by user"
+	}
+	if err := finishRun(run, status, message, runProjects(cases)); err != nil {
+		log.Printf("Failed to finish run %s: %v", run.ID, err)
+		return
+	}
+
+	// Cancelled runs prove nothing about their requirements.
+	if status == runStatusFinished {
+		requirementsWriteback.queue(run.ID)
+	}
+}
+
+func runProjects(cases []TestCase) []uuid.UUID {
+	var projects []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for _, tc := range cases {
+		if !seen[tc.ProjectID] {
+			seen[tc.ProjectID] = true
+			projects = append(projects, tc.ProjectID)
+		}
+	}
+	return projects
+}
+
+func skippedResult(tcID uuid.UUID, reason string) TestCaseRunResult {
+	return TestCaseRunResult{
+		TestCaseID: tcID,
+		Status:     executor.StatusSkipped,
+		Message:    reason,
+		RunTime:    time.Now(),
+	}
+}
+
+// runCase executes a test case with the run environment interpolated into
+// its spec. Secret values are masked out of everything that gets stored.
+func runCase(ctx context.Context, tc TestCase, defaults executor.Policy, env *runEnvironment) TestCaseRunResult {
+	runTime := time.Now()
+	result := TestCaseRunResult{TestCaseID: tc.ID, RunTime: runTime}
+
+	rows, err := loadParameterRows(tc)
+	if err != nil {
+		result.Status = executor.StatusError
+		result.Message = err.Error()
+		return result
+	}
+
+	if rows == nil {
+		spec, err := executor.InterpolateJSON(tc.JSONData, env.vars)
+		if err != nil {
+			result.Status = executor.StatusError
+			result.Message = err.Error()
+			return result
+		}
+
+		attempts := runSpec(ctx, spec, defaults)
+		result.AttemptLog = attemptLog(attempts, env)
+
+		last := result.AttemptLog[len(result.AttemptLog)-1]
+		result.Status = last.Status
+		result.Message = last.Message
+		result.Output = last.Output
+	} else {
+		result.Iterations = runIterations(ctx, tc.JSONData, rows, defaults, env)
+		result.Status, result.Message = summarizeIterations(result.Iterations)
+	}
+
+	result.DurationMs = time.Since(runTime).Milliseconds()
+	return result
+}
+
+func runSpec(ctx context.Context, spec json.RawMessage, defaults executor.Policy) []executor.Result {
+	return executors.RunWithPolicy(ctx, spec, executor.PolicyFor(spec, defaults))
+}
+
+func attemptLog(attempts []executor.Result, env *runEnvironment) []TestRunAttempt {
+	entries := make([]TestRunAttempt, len(attempts))
+	for i, res := range attempts {
+		entries[i] = TestRunAttempt{
+			Attempt:    i + 1,
+			Status:     res.Status,
+			Message:    env.mask(res.Message),
+			Output:     env.maskJSON(res.Output),
+			DurationMs: res.Duration.Milliseconds(),
+			StartedAt:  res.StartedAt,
+		}
+	}
+	return entries
+}
+
+// runIterations executes the spec once per parameter row with {{name}}
+// placeholders replaced by the row values.
+func runIterations(ctx context.Context, spec json.RawMessage, rows []map[string]interface{}, defaults executor.Policy, env *runEnvironment) []TestRunIteration {
+	iterations := make([]TestRunIteration, len(rows))
+	for i, row := range rows {
+		it := TestRunIteration{Iteration: i + 1, Parameters: row}
+		start := time.Now()
+
+		iterSpec, err := executor.InterpolateJSON(spec, env.with(row))
+		switch {
+		case ctx.Err() != nil:
+			it.Status, it.Message = executor.StatusSkipped, "run cancelled"
+		case err != nil:
+			it.Status, it.Message = executor.StatusError, err.Error()
+		default:
+			attempts := runSpec(ctx, iterSpec, defaults)
+			last := attempts[len(attempts)-1]
+			it.Status, it.Message, it.Output = last.Status, env.mask(last.Message), env.maskJSON(last.Output)
+			it.Attempts = len(attempts)
+			it.AttemptLog = attemptLog(attempts, env)
+		}
+
+		it.DurationMs = time.Since(start).Milliseconds()
+		iterations[i] = it
+	}
+	return iterations
+}
+
+// summarizeIterations picks the parent status: failed over error over
+// skipped over passed.
+func summarizeIterations(iterations []TestRunIteration) (string, string) {
+	if len(iterations) == 0 {
+		return executor.StatusSkipped, "parameter table is empty"
+	}
+
+	for _, status := range []string{executor.StatusFailed, executor.StatusError, executor.StatusSkipped} {
+		count := 0
+		var first *TestRunIteration
+		for i := range iterations {
+			if iterations[i].Status == status {
+				count++
+				if first == nil {
+					first = &iterations[i]
+				}
+			}
+		}
+		if count > 0 {
+			return status, fmt.Sprintf("%d of %d iterations %s, first: iteration %d: %s",
+				count, len(iterations), status, first.Iteration, first.Message)
+		}
+	}
+	return executor.StatusPassed, ""
+}
+
+func abortRun(run *TestRun, cause error) {
+	log.Printf("Run %s aborted: %v", run.ID, cause)
+	if err := finishRun(run, runStatusError, cause.Error(), nil); err != nil {
+		log.Printf("Failed to finish run %s: %v", run.ID, err)
+	}
+}
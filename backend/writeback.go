@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"zis/internal/config"
+	"zis/internal/executor"
+	"zis/internal/requirements"
+
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+var errRunNotFinished = errors.New("run is not finished")
+
+type RequirementVerdict struct {
+	RunID          uuid.UUID  `json:"run_id"`
+	RequirementID  uuid.UUID  `json:"requirement_id"`
+	RequirementKey string     `json:"requirement_key"`
+	Verdict        string     `json:"verdict"`
+	Total          int        `json:"total"`
+	Passed         int        `json:"passed"`
+	Failed         int        `json:"failed"`
+	ReportedAt     *time.Time `json:"reported_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+const writebackBatchSize = 20
+
+// writebackDispatcher sends stored verdicts to the requirements provider in
+// the background. Verdicts are leased while they are being sent and marked
+// reported only once the provider accepted them, so a crashed replica only
+// delays them until the lease runs out.
+type writebackDispatcher struct {
+	wake    chan struct{}
+	timeout time.Duration
+	lease   time.Duration
+	wg      sync.WaitGroup
+}
+
+func startRequirementsWriteback(ctx context.Context) *writebackDispatcher {
+	cfg := config.GetConfig()
+	d := &writebackDispatcher{
+		wake:    make(chan struct{}, 1),
+		timeout: cfg.Requirements.Timeout,
+		lease:   2 * cfg.Requirements.Timeout,
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(cfg.Requirements.WritebackInterval)
+		defer ticker.Stop()
+
+		for {
+			for ctx.Err() == nil {
+				n, _, err := d.sendVerdicts(ctx, uuid.NullUUID{})
+				if err != nil {
+					log.Printf("Requirement writeback failed: %v", err)
+					break
+				}
+				if n < writebackBatchSize {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-d.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+	return d
+}
+
+// queue stores the verdicts of a finished run and wakes the dispatcher to
+// send them. Only local queries run here; the provider is called later.
+func (d *writebackDispatcher) queue(runID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	if err := recordVerdicts(ctx, runID); err != nil {
+		log.Printf("Failed to record requirement verdicts for run %s: %v", runID, err)
+		return
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *writebackDispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// writebackRun pushes a verdict for every requirement the run exercised to
+// the requirements provider. Verdicts are computed once per run and stored,
+// so calling this again for the same run only retries verdicts that have
+// not been reported yet and are not being sent right now.
+func writebackRun(ctx context.Context, runID uuid.UUID) (int, error) {
+	var status string
+	var finishedAt sql.NullTime
+	err := db.QueryRowContext(ctx, `SELECT status, finished_at FROM test_runs WHERE id = $1`, runID).Scan(&status, &finishedAt)
+	if err != nil {
+		return 0, err
+	}
+	if status != runStatusFinished || !finishedAt.Valid {
+		return 0, errRunNotFinished
+	}
+
+	if err := recordVerdicts(ctx, runID); err != nil {
+		return 0, err
+	}
+
+	reported := 0
+	for {
+		n, ok, err := requirementsWriteback.sendVerdicts(ctx, uuid.NullUUID{UUID: runID, Valid: true})
+		reported += ok
+		if err != nil || n < writebackBatchSize {
+			return reported, err
+		}
+	}
+}
+
+// sendVerdicts leases a batch of unreported verdicts, optionally of a single
+// run, and reports them. It returns how many were leased and how many the
+// provider accepted; the error is the first failed report.
+func (d *writebackDispatcher) sendVerdicts(ctx context.Context, runID uuid.NullUUID) (int, int, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE requirement_verdicts v
+		SET claimed_until = CURRENT_TIMESTAMP + $2::double precision * INTERVAL '1 millisecond'
+		FROM requirements r, test_runs t
+		WHERE r.id = v.requirement_id AND t.id = v.run_id AND (v.run_id, v.requirement_id) IN (
+			SELECT run_id, requirement_id FROM requirement_verdicts
+			WHERE reported_at IS NULL AND (claimed_until IS NULL OR claimed_until <= CURRENT_TIMESTAMP)
+				AND ($3::uuid IS NULL OR run_id = $3)
+			ORDER BY created_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT $1
+		)
+		RETURNING v.run_id, v.requirement_id, r.project_id, r.key, COALESCE(r.external_id, ''),
+			v.verdict, v.total, v.passed, v.failed, t.finished_at
+	`, writebackBatchSize, d.lease.Milliseconds(), runID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	type leased struct {
+		runID         uuid.UUID
+		requirementID uuid.UUID
+		verdict       requirements.Verdict
+	}
+	var batch []leased
+	for rows.Next() {
+		var l leased
+		var projectID uuid.UUID
+		v := &l.verdict
+		if err := rows.Scan(&l.runID, &l.requirementID, &projectID, &v.Key, &v.ExternalID,
+			&v.Verdict, &v.Total, &v.Passed, &v.Failed, &v.VerifiedAt); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		v.Project = projectID.String()
+		if externalRequirements() {
+			v.Project = requirementsProjects[projectID]
+		} else if v.ExternalID == "" {
+			v.ExternalID = l.requirementID.String()
+		}
+		v.RunID = l.runID.String()
+		batch = append(batch, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	// Verdicts are sent concurrently so that the whole batch finishes within
+	// one timeout, well inside the lease.
+	var wg sync.WaitGroup
+	errs := make([]error, len(batch))
+	for i, l := range batch {
+		wg.Add(1)
+		go func(i int, l leased) {
+			defer wg.Done()
+			errs[i] = d.report(ctx, l.runID, l.requirementID, l.verdict)
+		}(i, l)
+	}
+	wg.Wait()
+
+	reported := 0
+	var firstErr error
+	for _, err := range errs {
+		if err == nil {
+			reported++
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return len(batch), reported, firstErr
+}
+
+// report sends one leased verdict and records the outcome. A failed verdict
+// is released right away so that the next pass retries it.
+func (d *writebackDispatcher) report(ctx context.Context, runID, requirementID uuid.UUID, v requirements.Verdict) error {
+	sendCtx, cancel := context.WithTimeout(ctx, d.timeout)
+	err := requirementsProvider.Report(sendCtx, v)
+	cancel()
+
+	if err == nil {
+		_, dbErr := db.Exec(`
+			UPDATE requirement_verdicts SET reported_at = CURRENT_TIMESTAMP, claimed_until = NULL, last_error = NULL
+			WHERE run_id = $1 AND requirement_id = $2
+		`, runID, requirementID)
+		if dbErr != nil {
+			log.Printf("Failed to mark verdict %s for run %s reported: %v", v.Key, runID, dbErr)
+		}
+		return nil
+	}
+
+	log.Printf("Writeback of %s for run %s failed: %v", v.Key, runID, err)
+	_, dbErr := db.Exec(`
+		UPDATE requirement_verdicts SET claimed_until = NULL, last_error = $3
+		WHERE run_id = $1 AND requirement_id = $2
+	`, runID, requirementID, err.Error())
+	if dbErr != nil {
+		log.Printf("Failed to release verdict %s for run %s: %v", v.Key, runID, dbErr)
+	}
+	return err
+}
+
+// recordVerdicts aggregates the run's results per linked requirement. A
+// verdict that already exists is kept as is.
+func recordVerdicts(ctx context.Context, runID uuid.UUID) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT l.requirement_id, r.project_id, COALESCE(r.external_id, ''),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE res.status = $2),
+			COUNT(*) FILTER (WHERE res.status IN ($3, $4))
+		FROM test_run_results res
+		JOIN test_case_requirements l ON l.test_case_id = res.test_case_id
+		JOIN requirements r ON r.id = l.requirement_id
+		WHERE res.run_id = $1
+		GROUP BY l.requirement_id, r.project_id, r.external_id
+	`, runID, executor.StatusPassed, executor.StatusFailed, executor.StatusError)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type tally struct {
+		requirementID         uuid.UUID
+		total, passed, failed int
+	}
+	var tallies []tally
+	for rows.Next() {
+		var t tally
+		var projectID uuid.UUID
+		var externalID string
+		if err := rows.Scan(&t.requirementID, &projectID, &externalID, &t.total, &t.passed, &t.failed); err != nil {
+			return err
+		}
+		// An external provider only knows about the requirements it owns.
+		if externalRequirements() {
+			if _, ok := requirementsProjects[projectID]; !ok || externalID == "" {
+				continue
+			}
+		}
+		tallies = append(tallies, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, t := range tallies {
+		verdict := requirements.Decide(t.total, t.passed, t.failed)
+		if verdict == "" {
+			continue
+		}
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO requirement_verdicts (run_id, requirement_id, verdict, total, passed, failed)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (run_id, requirement_id) DO NOTHING
+		`, runID, t.requirementID, verdict, t.total, t.passed, t.failed)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listRunVerdicts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	runID, err := uuid.Parse(ps.ByName("runId"))
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT v.run_id, v.requirement_id, r.key, v.verdict, v.total, v.passed, v.failed,
+			v.reported_at, COALESCE(v.last_error, ''), v.created_at
+		FROM requirement_verdicts v JOIN requirements r ON r.id = v.requirement_id
+		WHERE v.run_id = $1
+		ORDER BY r.key
+	`, runID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	verdicts := []RequirementVerdict{}
+	for rows.Next() {
+		var v RequirementVerdict
+		var reportedAt sql.NullTime
+		if err := rows.Scan(&v.RunID, &v.RequirementID, &v.RequirementKey, &v.Verdict, &v.Total, &v.Passed, &v.Failed,
+			&reportedAt, &v.LastError, &v.CreatedAt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if reportedAt.Valid {
+			v.ReportedAt = &reportedAt.Time
+		}
+		verdicts = append(verdicts, v)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verdicts)
+}
+
+func writebackRunHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	runID, err := uuid.Parse(ps.ByName("runId"))
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	n, err := writebackRun(r.Context(), runID)
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	case err == errRunNotFinished:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"run_id":   runID,
+		"provider": requirementsProvider.Name(),
+		"reported": n,
+	})
+}